@@ -1,83 +1,812 @@
 package analyzer
 
-import v2 "customs/ast"
+import (
+	v2 "customs/ast"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
 
 type Analyzer struct {
-	Stmt  []v2.Stmt
-	stack map[string]v2.Token
-	local map[string]v2.Token
+	Stmt        []v2.Stmt
+	Diagnostics v2.Diagnostics
+	scopes      []map[string]v2.Token
+	constraints map[string]v2.ConstraintStmt
+	unresolved  map[string]bool
+	reported    map[string]bool
+	// elements holds the element type of list literals and of the lets and enums bound to a list,
+	// keyed by the position of the bracket or of the declared name
+	elements map[v2.DebugInfo]v2.LiteralType
+	// asserts are those of the constraint being analyzed and path the object of the assert being analyzed,
+	// an assert may refer to the other fields of its object
+	asserts []v2.AssertStmt
+	path    []string
+	// constants is the number of scopes holding the enums and the lets of the constraint being analyzed
+	constants int
+	// types holds the assert declaring the type of each field of the constraint being analyzed, keyed by its dotted path,
+	// and instants the names in scope of the fields holding a date or a date-time, keyed by their position
+	types    map[string]v2.AssertStmt
+	instants map[v2.DebugInfo]bool
 }
 
 func NewAnalyzer(stmt []v2.Stmt) Analyzer {
-	return Analyzer{Stmt: stmt, stack: make(map[string]v2.Token), local: make(map[string]v2.Token)}
+	return Analyzer{
+		Stmt:        stmt,
+		scopes:      []map[string]v2.Token{make(map[string]v2.Token)},
+		constraints: make(map[string]v2.ConstraintStmt),
+		unresolved:  make(map[string]bool),
+		reported:    make(map[string]bool),
+		elements:    make(map[v2.DebugInfo]v2.LiteralType),
+		instants:    make(map[v2.DebugInfo]bool),
+	}
+}
+
+// Analyze checks every statement, all problems are collected into Diagnostics
+// and the returned error joins those which are errors rather than warnings.
+// Constraints in Stmt are replaced by their inherited form, with the lets and asserts of their parents merged in.
+func (r *Analyzer) Analyze() error {
+	r.Stmt = slices.Clone(r.Stmt)
+	r.collect()
+	for i, stmt := range r.Stmt {
+		if constraint, ok := stmt.(v2.ConstraintStmt); ok {
+			r.Stmt[i] = r.inherit(constraint)
+		}
+		r.Stmt[i].Accept(r)
+	}
+	return r.Diagnostics.Err()
+}
+
+// report records a diagnostic once, inherited statements are analyzed again in every child
+// and would otherwise repeat the problems of their parent.
+func (r *Analyzer) report(d v2.Diagnostic) {
+	if key := d.Error(); !r.reported[key] {
+		r.reported[key] = true
+		r.Diagnostics = append(r.Diagnostics, d)
+	}
 }
 
-func (r *Analyzer) Analyze() {
+// collect registers the constraints by name, so that a parent may be declared after its children.
+func (r *Analyzer) collect() {
 	for _, stmt := range r.Stmt {
-		stmt.Accept(r)
+		constraint, ok := stmt.(v2.ConstraintStmt)
+		if !ok {
+			continue
+		}
+		if prev, ok := r.constraints[constraint.Id.Literal]; ok {
+			r.report(v2.NewDiagnostic(v2.DuplicateIdentifier, v2.TokenSpan(constraint.Id), "Constraint %s already declared", constraint.Id.Literal).
+				WithNote(fmt.Sprintf("previously declared at %s", prev.Id.DebugInfo.String())))
+			continue
+		}
+		r.constraints[constraint.Id.Literal] = constraint
+	}
+}
+
+// ancestors returns the chain of parents of a constraint, the root first.
+// It reports and gives up on unknown or concrete parents and on cycles.
+func (r *Analyzer) ancestors(stmt v2.ConstraintStmt) ([]v2.ConstraintStmt, bool) {
+	var chain []v2.ConstraintStmt
+	path := []string{stmt.Id.Literal}
+	for current := stmt; current.ParentConstraint.Literal != ""; {
+		parentId := current.ParentConstraint
+		parent, ok := r.constraints[parentId.Literal]
+		if !ok {
+			r.report(v2.NewDiagnostic(v2.UnknownConstraint, v2.TokenSpan(parentId), "Constraint %s is not declared", parentId.Literal))
+			return nil, false
+		}
+		if !parent.IsAbstract {
+			r.report(v2.NewDiagnostic(v2.ConcreteParent, v2.TokenSpan(parentId), "Constraint %s cannot extend concrete constraint %s", current.Id.Literal, parentId.Literal).
+				WithFix(v2.Fix{Message: fmt.Sprintf("declare %s as abstract", parentId.Literal), Span: v2.TokenSpan(parent.Id), Replacement: "abstract constraint " + parent.Id.Literal}))
+			return nil, false
+		}
+		path = append(path, parent.Id.Literal)
+		if parent.Id.Literal == stmt.Id.Literal {
+			r.report(v2.NewDiagnostic(v2.InheritanceCycle, v2.TokenSpan(stmt.ParentConstraint), "Constraint %s inherits from itself", stmt.Id.Literal).
+				WithNote(strings.Join(path, " extends ")))
+			return nil, false
+		}
+		if slices.ContainsFunc(chain, func(c v2.ConstraintStmt) bool { return c.Id.Literal == parent.Id.Literal }) {
+			// the cycle does not pass through stmt, it is reported by its members
+			return nil, false
+		}
+		chain = append([]v2.ConstraintStmt{parent}, chain...)
+		current = parent
+	}
+	return chain, true
+}
+
+// inherit merges the lets, asserts and guarded asserts of the ancestors into the constraint. A let redeclared by a child
+// overrides the one of its parent in place, so that the inherited lets and asserts see the new value.
+func (r *Analyzer) inherit(stmt v2.ConstraintStmt) v2.ConstraintStmt {
+	chain, ok := r.ancestors(stmt)
+	if !ok {
+		r.unresolved[stmt.Id.Literal] = true
+	}
+	if len(chain) == 0 {
+		return stmt
+	}
+	var lets []v2.AssignStmt
+	var asserts []v2.AssertStmt
+	var whens []v2.WhenStmt
+	owners := make(map[string]string)
+	for _, c := range append(chain, stmt) {
+		for _, let := range c.LetStmts {
+			i := slices.IndexFunc(lets, func(l v2.AssignStmt) bool { return l.Id.Literal == let.Id.Literal })
+			// a let declared twice by the same constraint is left for the duplicate check
+			if i >= 0 && owners[let.Id.Literal] != c.Id.Literal {
+				lets[i] = let
+				owners[let.Id.Literal] = c.Id.Literal
+				continue
+			}
+			lets = append(lets, let)
+			owners[let.Id.Literal] = c.Id.Literal
+		}
+		asserts = append(asserts, c.AssertStmts...)
+		whens = append(whens, c.WhenStmts...)
+	}
+	stmt.LetStmts, stmt.AssertStmts, stmt.WhenStmts = lets, asserts, whens
+	return stmt
+}
+
+func (r *Analyzer) push() {
+	r.scopes = append(r.scopes, make(map[string]v2.Token))
+}
+
+func (r *Analyzer) pop() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *Analyzer) declare(token v2.Token) {
+	scope := r.scopes[len(r.scopes)-1]
+	if prev, ok := scope[token.Literal]; ok {
+		r.report(v2.NewDiagnostic(v2.DuplicateIdentifier, v2.TokenSpan(token), "Variable %s already declared", token.Literal).
+			WithNote(fmt.Sprintf("previously declared at %s", prev.DebugInfo.String())))
+		return
 	}
+	scope[token.Literal] = token
+}
+
+func (r *Analyzer) lookup(name string) (v2.Token, bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if v, ok := r.scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return v2.Token{}, false
+}
+
+// suggest returns the declared name closest to an undeclared one.
+func (r *Analyzer) suggest(name string) (string, bool) {
+	var names []string
+	for _, scope := range r.scopes {
+		for k := range scope {
+			names = append(names, k)
+		}
+	}
+	return closest(name, names)
+}
+
+// closest returns the candidate within two edits of name, ties go to the first in alphabetical order.
+func closest(name string, candidates []string) (string, bool) {
+	best, distance := "", 3
+	for _, k := range candidates {
+		if d := levenshtein(name, k); d < distance || (d == distance && k < best) {
+			best, distance = k, d
+		}
+	}
+	return best, best != ""
 }
 
 func (r *Analyzer) VisitConstraintStmt(stmt v2.ConstraintStmt) {
-	//TODO implement me
-	panic("implement me")
+	r.push()
+	defer r.pop()
+	r.constants = len(r.scopes)
+	for _, let := range stmt.LetStmts {
+		let.Accept(r)
+	}
+	r.annotations(stmt.Annotations, true)
+	r.asserts, r.path = stmt.Asserts(), nil
+	r.fieldKeys()
+	r.fieldTypes()
+	// the asserts of a guard only apply to some requests, they may mark a field differently than the others
+	r.fieldModifiers(stmt.AssertStmts)
+	for _, when := range stmt.WhenStmts {
+		r.fieldModifiers(when.AssertStmts)
+	}
+	for _, assert := range stmt.AssertStmts {
+		assert.Accept(r)
+	}
+	for _, when := range stmt.WhenStmts {
+		when.Accept(r)
+	}
+	// the asserts of a constraint with a broken parent are unknown rather than missing
+	if !stmt.IsAbstract && len(r.asserts) == 0 && !r.unresolved[stmt.Id.Literal] {
+		r.report(v2.NewDiagnostic(v2.ImplicitRequestDefinition, v2.TokenSpan(stmt.Id),
+			"Constraint %s does not define any request field", stmt.Id.Literal))
+	}
+}
+
+// VisitWhenStmt checks that the guard is a condition on the fields of the request. A name of the guard which is
+// neither a constant nor an asserted field is a field of the request as well, it is only tested by the guard.
+func (r *Analyzer) VisitWhenStmt(stmt v2.WhenStmt) {
+	r.push()
+	r.siblings()
+	for _, ident := range v2.Idents(stmt.Guard) {
+		if _, ok := r.lookup(ident.Literal); !ok {
+			ident.LiteralType = v2.Any
+			r.declare(ident)
+		}
+	}
+	if typ := stmt.Guard.Accept(r); typ != v2.Boolean && typ != v2.Any {
+		r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(stmt.Guard), "Type mismatch, a guard must be Boolean, found %s", typ))
+	}
+	r.pop()
+	for _, assert := range stmt.AssertStmts {
+		assert.Accept(r)
+	}
 }
 
 func (r *Analyzer) VisitAssertStmt(stmt v2.AssertStmt) {
-	//TODO implement me
-	panic("implement me")
+	annotations := r.annotations(stmt.Annotations, false)
+	r.modifiers(stmt)
+	r.violations(stmt)
+	// the default is a constant, the fields of the request are not in its scope
+	value := v2.Any
+	if stmt.Default != nil {
+		value = r.constant(stmt.Default)
+	}
+	path := r.path
+	defer func() { r.path = path }()
+	// a dotted path is nested in each of its fields
+	for _, segment := range stmt.Path {
+		r.push()
+		defer r.pop()
+		segment.LiteralType = v2.Any
+		r.declare(segment)
+		r.path = append(slices.Clip(r.path), segment.Literal)
+	}
+	r.push()
+	defer r.pop()
+	r.siblings()
+	r.push()
+	defer r.pop()
+	// the asserted field is a request value, its type is only known at runtime unless it is declared
+	field := stmt.Id
+	if stmt.Alias.Literal != "" {
+		field = stmt.Alias
+	}
+	// declared reports an unknown type or format, the field takes the type it is declared with by any assert
+	r.declared(stmt)
+	field = r.typed(field, stmt.Id.Literal)
+	r.checkDefault(stmt, field.LiteralType, value)
+	r.checkExample(stmt, field.LiteralType, annotations["example"])
+	fields := []v2.Token{field}
+	if len(stmt.Fields) > 0 {
+		fields = make([]v2.Token, 0, len(stmt.Fields))
+		for _, field := range stmt.Fields {
+			fields = append(fields, r.typed(field, field.Literal))
+		}
+	}
+	// the elements of a list are request values as well
+	if stmt.Quantifier.Literal != "" {
+		fields = append(slices.Clip(fields), stmt.Element)
+	}
+	for _, field := range fields {
+		r.declare(field)
+	}
+	r.path = append(slices.Clip(r.path), stmt.Id.Literal)
+	for _, expr := range stmt.Exprs {
+		if typ := expr.Accept(r); typ != v2.Boolean && typ != v2.Any {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(expr), "Type mismatch, an assertion must be Boolean, found %s", typ))
+		}
+	}
+	// nested asserts see the field of their enclosing asserts, but not the fields nested in their siblings
+	for _, nested := range stmt.Stmts {
+		nested.Accept(r)
+	}
 }
 
-func (r *Analyzer) VisitAssignStmt(stmt v2.AssignStmt) {
-	if _, ok := r.local[stmt.Id.Literal]; ok {
-		panic("Variable already declared")
+// annotations checks the annotations of a constraint or of an assert against the registry,
+// their arguments are constants. It returns the types of the arguments of each annotation.
+func (r *Analyzer) annotations(annotations []v2.Annotation, constraint bool) map[string][]v2.LiteralType {
+	seen := make(map[string]bool)
+	types := make(map[string][]v2.LiteralType)
+	for _, annotation := range annotations {
+		name, span := annotation.Name.Literal, v2.AnnotationSpan(annotation)
+		spec, ok := v2.Annotations[name]
+		if !ok {
+			d := v2.NewDiagnostic(v2.UnknownAnnotation, span, "Annotation @%s is not declared", name).
+				WithNote("known annotations are @" + strings.Join(annotationNames(), ", @"))
+			if suggestion, ok := closest(name, annotationNames()); ok {
+				d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '@%s'?", suggestion), Span: span, Replacement: "@" + suggestion})
+			}
+			r.report(d)
+			continue
+		}
+		switch {
+		case seen[name]:
+			r.report(v2.NewDiagnostic(v2.InvalidAnnotation, span, "Annotation @%s is repeated", name))
+		case constraint && !spec.Constraint:
+			r.report(v2.NewDiagnostic(v2.InvalidAnnotation, span, "Annotation @%s does not apply to a constraint", name))
+		case !constraint && !spec.Assert:
+			r.report(v2.NewDiagnostic(v2.InvalidAnnotation, span, "Annotation @%s does not apply to an assert", name))
+		}
+		args := r.annotationArgs(annotation, spec)
+		if !seen[name] {
+			types[name] = args
+		}
+		seen[name] = true
+	}
+	return types
+}
+
+// annotationArgs checks the arguments of an annotation, they are analyzed in the scope of the lets
+// since an annotation describes the field rather than a request.
+func (r *Analyzer) annotationArgs(annotation v2.Annotation, spec v2.AnnotationSpec) []v2.LiteralType {
+	name, args := annotation.Name.Literal, annotation.Args
+	if len(spec.Choices) > 0 {
+		var choice v2.Token
+		if len(args) == 1 {
+			choice, _ = args[0].(v2.Token)
+		}
+		if choice.TokenType != v2.Ident || !slices.Contains(spec.Choices, choice.Literal) {
+			d := v2.NewDiagnostic(v2.InvalidAnnotation, v2.AnnotationSpan(annotation), "Annotation @%s takes one of %s", name, strings.Join(spec.Choices, ", "))
+			if suggestion, ok := closest(choice.Literal, spec.Choices); ok && choice.TokenType == v2.Ident {
+				d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '%s'?", suggestion), Span: v2.TokenSpan(choice), Replacement: suggestion})
+			}
+			r.report(d)
+		}
+		return nil
+	}
+	if len(args) != len(spec.Params) {
+		r.report(v2.NewDiagnostic(v2.ArgumentCount, v2.AnnotationSpan(annotation), "Annotation @%s takes %d arguments, found %d", name, len(spec.Params), len(args)))
+		return nil
+	}
+	types := make([]v2.LiteralType, 0, len(args))
+	for i, arg := range args {
+		typ := r.constant(arg)
+		if !(v2.Signature{}).Accepts(spec.Params[i], typ) {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(arg), "Type mismatch, argument %d of @%s must be %s, found %s", i+1, name, spec.Params[i], typ))
+		}
+		types = append(types, typ)
+	}
+	return types
+}
+
+// constant analyzes an expression folded at compile time, only the enums and the lets are in its scope.
+func (r *Analyzer) constant(expr v2.Expr) v2.LiteralType {
+	scopes := r.scopes
+	r.scopes = r.scopes[:r.constants]
+	defer func() { r.scopes = scopes }()
+	return expr.Accept(r)
+}
+
+// violations checks that the placeholders of the messages name a let, they are replaced at compile time
+// so the fields of the request cannot be interpolated.
+func (r *Analyzer) violations(stmt v2.AssertStmt) {
+	var names []string
+	for _, scope := range r.scopes[:r.constants] {
+		for name := range scope {
+			names = append(names, name)
+		}
+	}
+	for _, violation := range stmt.Violations {
+		message := violation.Message
+		for _, match := range v2.Placeholder.FindAllStringSubmatch(message.Literal, -1) {
+			if slices.Contains(names, match[1]) {
+				continue
+			}
+			d := v2.NewDiagnostic(v2.UndeclaredIdentifier, v2.TokenSpan(message), "Placeholder %s does not name a let", match[0]).
+				WithNote("placeholders are replaced at compile time, the fields of the request cannot be interpolated")
+			if suggestion, ok := closest(match[1], names); ok {
+				d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '%s'?", suggestion), Span: v2.TokenSpan(message),
+					Replacement: strings.Replace(message.Text(), match[0], "{"+suggestion+"}", 1)})
+			}
+			r.report(d)
+		}
+	}
+}
+
+// declared returns the type declared for the asserted field, `assert id: integer`, or Any.
+// A format declared as the type stands for a string in that format.
+func (r *Analyzer) declared(stmt v2.AssertStmt) v2.LiteralType {
+	if stmt.Type.Literal == "" {
+		return v2.Any
+	}
+	typ, ok := v2.TypeNames[stmt.Type.Literal]
+	switch {
+	case v2.Formats[stmt.Type.Literal]:
+		typ = v2.String
+	case !ok:
+		r.report(v2.NewDiagnostic(v2.UnknownType, v2.TokenSpan(stmt.Type), "Unknown type %s", stmt.Type.Literal).
+			WithNote("known types are " + strings.Join(typeNames(), ", ") + " and the formats " + strings.Join(formatNames(), ", ")))
+		return v2.Any
+	}
+	if format := stmt.Format; format.Literal != "" {
+		if !v2.Formats[format.Literal] {
+			d := v2.NewDiagnostic(v2.UnknownFormat, v2.TokenSpan(format), "Unknown format %s", format.Literal)
+			if suggestion, ok := closest(format.Literal, formatNames()); ok {
+				d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '%s'?", suggestion), Span: v2.TokenSpan(format), Replacement: suggestion})
+			}
+			r.report(d)
+		} else if stmt.Type.Literal != "string" {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.TokenSpan(format), "Type mismatch, a format only applies to string, found %s", stmt.Type.Literal))
+		}
+	}
+	return typ
+}
+
+// modifiers reports a field marked both required and optional, or twice with the same modifier.
+func (r *Analyzer) modifiers(stmt v2.AssertStmt) {
+	seen := make(map[v2.TokenType]bool)
+	for _, modifier := range stmt.Modifiers {
+		switch {
+		case seen[modifier.TokenType]:
+			r.report(v2.NewDiagnostic(v2.ConflictingModifiers, v2.TokenSpan(modifier), "Modifier %s is repeated", modifier.Literal))
+		case modifier.TokenType == v2.Required && seen[v2.Optional], modifier.TokenType == v2.Optional && seen[v2.Required]:
+			r.report(v2.NewDiagnostic(v2.ConflictingModifiers, v2.TokenSpan(modifier), "Field %s cannot be both required and optional", stmt.Id.Text()))
+		}
+		seen[modifier.TokenType] = true
 	}
+}
+
+// checkDefault checks that the default value of a field is of its declared type, only a nullable field defaults to null.
+func (r *Analyzer) checkDefault(stmt v2.AssertStmt, typ, value v2.LiteralType) {
+	switch {
+	case stmt.Default == nil || value == v2.Any:
+	case value == v2.Nil:
+		if !stmt.Modifier(v2.Nullable) {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(stmt.Default), "Type mismatch, field %s is not nullable and cannot default to null", stmt.Id.Text()).
+				WithFix(v2.Fix{Message: "mark the field as nullable", Span: v2.TokenSpan(stmt.Id), Replacement: "nullable " + stmt.Id.Text()}))
+		}
+	case typ == v2.Any || typ == value || (typ == v2.Float && value == v2.Integer):
+	default:
+		r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(stmt.Default), "Type mismatch, the default of %s must be %s, found %s", stmt.Id.Text(), typ, value))
+	}
+}
+
+// checkExample checks that the example of a field given by @example is of its declared type, the first one
+// is checked when it is repeated.
+func (r *Analyzer) checkExample(stmt v2.AssertStmt, typ v2.LiteralType, args []v2.LiteralType) {
+	if len(args) != 1 {
+		return
+	}
+	switch value := args[0]; {
+	case typ == v2.Any || value == v2.Any || typ == value || (typ == v2.Float && value == v2.Integer):
+	case value == v2.Nil && stmt.Modifier(v2.Nullable):
+	default:
+		for _, annotation := range stmt.Annotations {
+			if annotation.Name.Literal == "example" {
+				r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(annotation.Args[0]), "Type mismatch, the example of %s must be %s, found %s", stmt.Id.Text(), typ, value))
+				return
+			}
+		}
+	}
+}
+
+// fieldKeys reports the fields of an object rendered under the same key, `user_id` and `userId` are both UserId,
+// and the names which have no key at all.
+func (r *Analyzer) fieldKeys() {
+	keys := make(map[string]v2.Token)
+	eachField(r.asserts, nil, func(path []v2.Token, field v2.Token, _ *v2.AssertStmt) {
+		key := v2.PascalCase(field.Literal)
+		if key == "" {
+			r.report(v2.NewDiagnostic(v2.InvalidFieldName, v2.TokenSpan(field), "Field name %s cannot be rendered, it has no letter or digit", field.Text()))
+			return
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			key = v2.PascalCase(path[i].Literal) + "." + key
+		}
+		prev, ok := keys[key]
+		if !ok {
+			keys[key] = field
+			return
+		}
+		if prev.Literal != field.Literal {
+			r.report(v2.NewDiagnostic(v2.InvalidFieldName, v2.TokenSpan(field), "Fields %s and %s are both rendered as %s", prev.Text(), field.Text(), v2.PascalCase(field.Literal)).
+				WithNote(fmt.Sprintf("%s is asserted at %s", prev.Text(), prev.DebugInfo.String())))
+		}
+	})
+}
+
+// eachField calls visit with every field named by the asserts and the path of its object, the segments of a dotted path
+// and the fields of a cross-field assert included. The assert is passed along for the field it declares, nil otherwise.
+func eachField(asserts []v2.AssertStmt, path []v2.Token, visit func(path []v2.Token, field v2.Token, stmt *v2.AssertStmt)) {
+	for i := range asserts {
+		stmt := &asserts[i]
+		if len(stmt.Fields) > 0 {
+			for _, field := range stmt.Fields {
+				visit(path, field, nil)
+			}
+			continue
+		}
+		full := path
+		for _, segment := range stmt.Path {
+			visit(full, segment, nil)
+			full = append(slices.Clip(full), segment)
+		}
+		visit(full, stmt.Id, stmt)
+		eachField(stmt.Stmts, append(slices.Clip(full), stmt.Id), visit)
+	}
+}
+
+// fieldTypes collects the type each field is declared with, a field declared again by the constraint
+// or by one of its parents must be declared with the same type.
+func (r *Analyzer) fieldTypes() {
+	r.types = make(map[string]v2.AssertStmt)
+	eachField(r.asserts, nil, func(path []v2.Token, field v2.Token, stmt *v2.AssertStmt) {
+		if stmt == nil || stmt.Type.Literal == "" {
+			return
+		}
+		key := fieldKey(path, field)
+		prev, ok := r.types[key]
+		if !ok {
+			r.types[key] = *stmt
+			return
+		}
+		if typeName(prev) != typeName(*stmt) {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.TokenSpan(stmt.Type), "Type mismatch, field %s is already declared as %s, found %s", field.Text(), typeName(prev), typeName(*stmt)).
+				WithNote(fmt.Sprintf("previously declared at %s", prev.Type.DebugInfo.String())))
+		}
+	})
+}
+
+// fieldModifiers reports a field marked required by one assert and optional by another, and a field
+// given a default by more than one assert. The asserts are those of the constraint and of its parents.
+func (r *Analyzer) fieldModifiers(asserts []v2.AssertStmt) {
+	required := make(map[string]v2.Token)
+	defaults := make(map[string]v2.AssertStmt)
+	eachField(asserts, nil, func(path []v2.Token, field v2.Token, stmt *v2.AssertStmt) {
+		if stmt == nil {
+			return
+		}
+		key := fieldKey(path, field)
+		// the modifiers of a single assert are checked by modifiers
+		prev, marked := required[key]
+		for _, modifier := range stmt.Modifiers {
+			if modifier.TokenType != v2.Required && modifier.TokenType != v2.Optional {
+				continue
+			}
+			if !marked {
+				required[key] = modifier
+				break
+			}
+			if prev.TokenType != modifier.TokenType {
+				r.report(v2.NewDiagnostic(v2.ConflictingModifiers, v2.TokenSpan(modifier), "Field %s cannot be both required and optional", field.Text()).
+					WithNote(fmt.Sprintf("marked %s at %s", prev.Literal, prev.DebugInfo.String())))
+				break
+			}
+		}
+		if stmt.Default == nil {
+			return
+		}
+		if prev, ok := defaults[key]; ok {
+			start := v2.ExprSpan(prev.Default).Start
+			r.report(v2.NewDiagnostic(v2.ConflictingModifiers, v2.ExprSpan(stmt.Default), "Field %s already has a default", field.Text()).
+				WithNote(fmt.Sprintf("previously defaulted at %s", start.String())))
+			return
+		}
+		defaults[key] = *stmt
+	})
+}
+
+// fieldKey joins the path of a field and its name with dots, `user.address.zip`.
+func fieldKey(path []v2.Token, field v2.Token) string {
+	names := make([]string, 0, len(path)+1)
+	for _, segment := range path {
+		names = append(names, segment.Literal)
+	}
+	return strings.Join(append(names, field.Literal), ".")
+}
+
+// typeName returns the type an assert declares, a format declared as the type stands for a string in that format.
+func typeName(stmt v2.AssertStmt) string {
+	switch {
+	case v2.Formats[stmt.Type.Literal]:
+		return "string format " + stmt.Type.Literal
+	case stmt.Format.Literal != "":
+		return stmt.Type.Literal + " format " + stmt.Format.Literal
+	}
+	return stmt.Type.Literal
+}
+
+// typed gives the name a field of the object being asserted is referred to by the type the field is declared with,
+// Any when no assert declares it.
+func (r *Analyzer) typed(token v2.Token, field string) v2.Token {
+	token.LiteralType = v2.Any
+	stmt, ok := r.types[strings.Join(append(slices.Clip(r.path), field), ".")]
+	if !ok {
+		return token
+	}
+	if typ, ok := v2.TypeNames[stmt.Type.Literal]; ok {
+		token.LiteralType = typ
+	} else if v2.Formats[stmt.Type.Literal] {
+		token.LiteralType = v2.String
+	}
+	if name := typeName(stmt); name == "string format date" || name == "string format date-time" {
+		r.instants[token.DebugInfo] = true
+	}
+	return token
+}
+
+// instant reports whether an expression is a point in time, a date or date-time field or now() shifted
+// by a number of seconds, such values can be compared with one another.
+func (r *Analyzer) instant(expr v2.Expr) bool {
+	switch expr := expr.(type) {
+	case v2.Token:
+		token, ok := r.lookup(expr.Literal)
+		return expr.TokenType == v2.Ident && ok && r.instants[token.DebugInfo]
+	case v2.CallExpr:
+		return expr.Callee.Literal == "now"
+	case v2.BinaryExpr:
+		call, ok := expr.Left.(v2.CallExpr)
+		return ok && call.Callee.Literal == "now" && (expr.Op.TokenType == v2.Plus || expr.Op.TokenType == v2.Minus)
+	}
+	return false
+}
+
+// siblings declares the fields of the object being asserted, so that an assert can be related
+// to the other fields of its object. A field asserted more than once is declared once, with its declared type.
+func (r *Analyzer) siblings() {
+	scope := r.scopes[len(r.scopes)-1]
+	for _, field := range v2.Siblings(r.asserts, r.path) {
+		if _, ok := scope[field.Literal]; !ok {
+			scope[field.Literal] = r.typed(field, field.Literal)
+		}
+	}
+}
+
+func (r *Analyzer) VisitAssignStmt(stmt v2.AssignStmt) {
 	stmt.Id.LiteralType = stmt.Expr.Accept(r)
-	r.local[stmt.Id.Literal] = stmt.Id
+	if stmt.Id.LiteralType == v2.List {
+		r.elements[stmt.Id.DebugInfo] = r.elementOf(stmt.Expr)
+	}
+	r.declare(stmt.Id)
+}
+
+func (r *Analyzer) VisitEnumStmt(stmt v2.EnumStmt) {
+	r.elements[stmt.Id.DebugInfo] = r.elementType(stmt.Values)
+	stmt.Id.LiteralType = v2.List
+	r.declare(stmt.Id)
+}
+
+// VisitMemberExpr types the field of a request value, only objects of the request have fields.
+func (r *Analyzer) VisitMemberExpr(expr v2.MemberExpr) v2.LiteralType {
+	if typ := expr.Expr.Accept(r); typ != v2.Any {
+		r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(expr), "Type mismatch, %s has no field %s", typ, expr.Name.Text()))
+	}
+	return v2.Any
+}
+
+func (r *Analyzer) VisitUniqueExpr(expr v2.UniqueExpr) v2.LiteralType {
+	if typ := expr.Expr.Accept(r); typ != v2.List && typ != v2.Any {
+		r.mismatch(expr.Op, typ)
+	}
+	return v2.Boolean
+}
+
+func (r *Analyzer) VisitListExpr(expr v2.ListExpr) v2.LiteralType {
+	r.elements[expr.LeftBracket.DebugInfo] = r.elementType(expr.Elements)
+	return v2.List
+}
+
+// elementType checks that the elements of a list share a type and returns it, integers and floats mix into floats.
+func (r *Analyzer) elementType(elements []v2.Expr) v2.LiteralType {
+	typ := v2.Any
+	for _, element := range elements {
+		switch t := element.Accept(r); {
+		case t == v2.Any || t == typ:
+		case typ == v2.Any:
+			typ = t
+		case IsNumeric(t) && IsNumeric(typ):
+			typ = v2.Float
+		default:
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(element), "Type mismatch, the elements of a list must share a type, expected %s, found %s", typ, t))
+		}
+	}
+	return typ
+}
+
+// elementOf returns the element type of a list literal or of a name bound to a list.
+func (r *Analyzer) elementOf(expr v2.Expr) v2.LiteralType {
+	switch v := expr.(type) {
+	case v2.ListExpr:
+		if typ, ok := r.elements[v.LeftBracket.DebugInfo]; ok {
+			return typ
+		}
+	case v2.Token:
+		if declared, ok := r.lookup(v.Literal); ok && v.TokenType == v2.Ident {
+			if typ, ok := r.elements[declared.DebugInfo]; ok {
+				return typ
+			}
+		}
+	}
+	return v2.Any
+}
+
+// mismatch reports an operator applied to operands of the wrong types,
+// the result is Any so that the error does not cascade to enclosing expressions.
+func (r *Analyzer) mismatch(op v2.Token, types ...v2.LiteralType) v2.LiteralType {
+	if len(types) == 1 {
+		r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.TokenSpan(op), "Type mismatch, cannot apply '%s' to %s", op.Literal, types[0]))
+	} else {
+		r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.TokenSpan(op), "Type mismatch, cannot apply '%s' to %s and %s", op.Literal, types[0], types[1]))
+	}
+	return v2.Any
 }
 
 func (r *Analyzer) VisitBinaryExpr(expr v2.BinaryExpr) (typ v2.LiteralType) {
 	switch expr.Op.TokenType {
-	case v2.Plus, v2.Minus, v2.Multiply, v2.Divide:
+	case v2.Plus, v2.Minus, v2.Multiply, v2.Divide, v2.Modulo:
 		left, right := expr.Left.Accept(r), expr.Right.Accept(r)
-		if left == v2.Integer && left == right {
-			typ = v2.Integer
+		// plus concatenates strings
+		if expr.Op.TokenType == v2.Plus && (left == v2.String || right == v2.String) && IsText(left) && IsText(right) {
+			typ = v2.String
 			return
 		}
-
-		if left == v2.Float && left == right {
-			typ = v2.Float
+		if !IsNumeric(left) || !IsNumeric(right) {
+			typ = r.mismatch(expr.Op, left, right)
 			return
 		}
-
-		// type conversion
-		if left == v2.Integer && right == v2.Float {
-			typ = v2.Float
+		if left == v2.Any || right == v2.Any {
+			typ = v2.Any
 			return
 		}
 
-		if left == v2.Float && right == v2.Integer {
-			typ = v2.Float
+		if left == v2.Integer && left == right {
+			typ = v2.Integer
 			return
 		}
 
-		panic("Type mismatch")
+		// type conversion
+		typ = v2.Float
+		return
 	case v2.Equal, v2.NotEqual, v2.LessThan, v2.LessThanOrEqual, v2.GreaterThan, v2.GreaterThanOrEqual:
 		left, right := expr.Left.Accept(r), expr.Right.Accept(r)
-		if left == right {
+		// any value can be tested for equality with null, but null cannot be ordered
+		nullable := (left == v2.Nil || right == v2.Nil) && (expr.Op.TokenType == v2.Equal || expr.Op.TokenType == v2.NotEqual)
+		if nullable || (left == right && left != v2.Nil) || left == v2.Any || right == v2.Any || (IsNumeric(left) && IsNumeric(right)) {
+			typ = v2.Boolean
+			return
+		}
+		// a date or a date-time is compared with the time of the request
+		if r.instant(expr.Left) && r.instant(expr.Right) {
 			typ = v2.Boolean
 			return
-		} else {
-			panic("Type mismatch")
 		}
+		r.mismatch(expr.Op, left, right)
+		typ = v2.Boolean
+		return
+	case v2.Contains, v2.StartsWith, v2.EndsWith, v2.Matches:
+		left, right := expr.Left.Accept(r), expr.Right.Accept(r)
+		if !IsText(left) || !IsText(right) {
+			r.mismatch(expr.Op, left, right)
+		} else if pattern, ok := expr.Right.(v2.Token); ok && expr.Op.TokenType == v2.Matches && pattern.TokenType == v2.Value {
+			if _, err := regexp.Compile(pattern.Literal); err != nil {
+				r.report(v2.NewDiagnostic(v2.InvalidPattern, v2.TokenSpan(pattern), "Invalid regular expression, %s", strings.TrimPrefix(err.Error(), "error parsing regexp: ")))
+			}
+		}
+		typ = v2.Boolean
+		return
+	case v2.In, v2.NotIn:
+		left, right := expr.Left.Accept(r), expr.Right.Accept(r)
+		if right != v2.List && right != v2.Any {
+			r.mismatch(expr.Op, left, right)
+		} else if element := r.elementOf(expr.Right); left != element && left != v2.Any && element != v2.Any && !(IsNumeric(left) && IsNumeric(element)) {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(expr), "Type mismatch, cannot look up %s in a list of %s", left, element))
+		}
+		typ = v2.Boolean
+		return
 	case v2.And, v2.Or:
 		left, right := expr.Left.Accept(r), expr.Right.Accept(r)
-		if left == v2.Boolean && left == right {
+		if (left == v2.Boolean || left == v2.Any) && (right == v2.Boolean || right == v2.Any) {
 			typ = v2.Boolean
 			return
-		} else {
-			panic("Type mismatch")
 		}
+		r.mismatch(expr.Op, left, right)
+		typ = v2.Boolean
+		return
 	}
 	return
 }
@@ -86,28 +815,166 @@ func (r *Analyzer) VisitUnaryExpr(expr v2.UnaryExpr) (typ v2.LiteralType) {
 	switch expr.Op.TokenType {
 	case v2.Plus, v2.Minus:
 		typ = expr.Expr.Accept(r)
-		if typ == v2.Integer || typ == v2.Float {
+		if IsNumeric(typ) {
 			return
 		}
-		panic("Type mismatch")
+		typ = r.mismatch(expr.Op, typ)
+		return
 	case v2.Not:
 		typ = expr.Expr.Accept(r)
-		if typ == v2.Boolean {
-			return
+		if typ != v2.Boolean && typ != v2.Any {
+			r.mismatch(expr.Op, typ)
 		}
-		panic("Type mismatch")
+		typ = v2.Boolean
+		return
 	}
 	return
 }
 
+func (r *Analyzer) VisitIsExpr(expr v2.IsExpr) v2.LiteralType {
+	typ := expr.Expr.Accept(r)
+	switch expr.Predicate.TokenType {
+	case v2.Empty:
+		// a string is empty without characters and a list without elements
+		if typ != v2.String && typ != v2.List && typ != v2.Any {
+			r.mismatch(expr.Op, typ)
+		}
+	case v2.Null:
+	default:
+		if _, ok := v2.TypeNames[expr.Predicate.Literal]; !ok {
+			r.report(v2.NewDiagnostic(v2.UnknownType, v2.TokenSpan(expr.Predicate), "Unknown type %s", expr.Predicate.Literal).
+				WithNote("known types are " + strings.Join(typeNames(), ", ")))
+		}
+	}
+	return v2.Boolean
+}
+
+func (r *Analyzer) VisitCallExpr(expr v2.CallExpr) v2.LiteralType {
+	args := make([]v2.LiteralType, 0, len(expr.Args))
+	for _, arg := range expr.Args {
+		args = append(args, arg.Accept(r))
+	}
+	name := expr.Callee.Literal
+	signature, ok := v2.Builtins[name]
+	if !ok {
+		d := v2.NewDiagnostic(v2.UnknownFunction, v2.TokenSpan(expr.Callee), "Function %s is not declared", name)
+		if suggestion, ok := closest(name, builtinNames()); ok {
+			d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '%s'?", suggestion), Span: v2.TokenSpan(expr.Callee), Replacement: suggestion})
+		}
+		r.report(d)
+		return v2.Any
+	}
+	params := signature.Params
+	if n := len(params); len(args) != n && !(signature.Variadic && len(args) > n) {
+		expected := fmt.Sprint(n)
+		if signature.Variadic {
+			expected = "at least " + expected
+		}
+		r.report(v2.NewDiagnostic(v2.ArgumentCount, v2.ExprSpan(expr), "Function %s takes %s arguments, found %d", name, expected, len(args)))
+		return signature.Result
+	}
+	result := signature.Result
+	if signature.Numeric {
+		result = v2.Integer
+	}
+	for i, arg := range args {
+		param := params[min(i, len(params)-1)]
+		if !signature.Accepts(param, arg) {
+			r.report(v2.NewDiagnostic(v2.TypeMismatch, v2.ExprSpan(expr.Args[i]), "Type mismatch, argument %d of %s must be %s, found %s", i+1, name, param, arg))
+			return signature.Result
+		}
+		switch {
+		case !signature.Numeric:
+		case arg == v2.Any:
+			result = v2.Any
+		case arg == v2.Float && result == v2.Integer:
+			result = v2.Float
+		}
+	}
+	return result
+}
+
 func (r *Analyzer) VisitToken(token v2.Token) (typ v2.LiteralType) {
 	if token.TokenType == v2.Ident {
-		if v, ok := r.local[token.Literal]; ok {
+		if v, ok := r.lookup(token.Literal); ok {
 			typ = v.LiteralType
 			return
 		}
-		panic("Variable not declared")
+		d := v2.NewDiagnostic(v2.UndeclaredIdentifier, v2.TokenSpan(token), "Variable %s not declared", token.Literal)
+		if name, ok := r.suggest(token.Literal); ok {
+			d = d.WithFix(v2.Fix{Message: fmt.Sprintf("did you mean '%s'?", name), Span: v2.TokenSpan(token), Replacement: name})
+		}
+		r.report(d)
+		typ = v2.Any
+		return
 	}
 	typ = token.LiteralType
 	return
 }
+
+func annotationNames() []string {
+	names := make([]string, 0, len(v2.Annotations))
+	for name := range v2.Annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func builtinNames() []string {
+	names := make([]string, 0, len(v2.Builtins))
+	for name := range v2.Builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsText reports whether a value of the given type can take part in string operations.
+func IsText(typ v2.LiteralType) bool {
+	return typ == v2.String || typ == v2.Any
+}
+
+// IsNumeric reports whether a value of the given type can take part in arithmetic.
+func IsNumeric(typ v2.LiteralType) bool {
+	return typ == v2.Integer || typ == v2.Float || typ == v2.Any
+}
+
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur := make([]int, len(t)+1)
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(t)]
+}
+
+func formatNames() []string {
+	names := make([]string, 0, len(v2.Formats))
+	for name := range v2.Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typeNames() []string {
+	names := make([]string, 0, len(v2.TypeNames))
+	for name := range v2.TypeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
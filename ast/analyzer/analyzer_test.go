@@ -1,9 +1,12 @@
 package analyzer
 
 import (
+	v2 "customs/ast"
 	parser2 "customs/ast/parser"
 	"customs/ast/scanner"
+	"errors"
 	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -26,3 +29,474 @@ func TestAnalyzer_VisitAssignStmt(t *testing.T) {
 	analyzer2 := NewAnalyzer(stmts)
 	analyzer2.Analyze()
 }
+
+func TestAnalyzer_Diagnostics(t *testing.T) {
+	input := `let x = 2;
+	let x = 3;
+	let y = "a" + 1;
+	let z = xx * 2;
+	constraint Empty {
+		let w = 1;
+	}`
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	parser := parser2.NewParser(lexer.Tokens)
+	stmts, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	analyzer2 := NewAnalyzer(stmts)
+	err = analyzer2.Analyze()
+
+	expected := []string{v2.DuplicateIdentifier, v2.TypeMismatch, v2.UndeclaredIdentifier, v2.ImplicitRequestDefinition}
+	if len(analyzer2.Diagnostics) != len(expected) {
+		t.Fatalf("Analyze() reported %v, want codes %v", analyzer2.Diagnostics, expected)
+	}
+	for i, d := range analyzer2.Diagnostics {
+		if d.Code != expected[i] {
+			t.Errorf("Diagnostics[%d] = %v, want code %s", i, d, expected[i])
+		}
+	}
+	if fix := analyzer2.Diagnostics[2].Fix; fix == nil || fix.Replacement != "x" {
+		t.Errorf("Diagnostics[2].Fix = %v, want replacement x", fix)
+	}
+
+	var d v2.Diagnostic
+	if !errors.As(err, &d) || d.Code != v2.DuplicateIdentifier {
+		t.Errorf("errors.As(%v) = %v", err, d)
+	}
+	if !errors.Is(err, v2.Diagnostic{Code: v2.TypeMismatch}) {
+		t.Errorf("errors.Is(%v, %s) = false", err, v2.TypeMismatch)
+	}
+	// warnings are not errors
+	if errors.Is(err, v2.Diagnostic{Code: v2.ImplicitRequestDefinition}) {
+		t.Errorf("errors.Is(%v, %s) = true", err, v2.ImplicitRequestDefinition)
+	}
+}
+
+func analyze(t *testing.T, input string) Analyzer {
+	t.Helper()
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	parser := parser2.NewParser(lexer.Tokens)
+	stmts, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	analyzer2 := NewAnalyzer(stmts)
+	_ = analyzer2.Analyze()
+	return analyzer2
+}
+
+func codes(diagnostics v2.Diagnostics) []string {
+	var codes []string
+	for _, d := range diagnostics {
+		codes = append(codes, d.Code)
+	}
+	return codes
+}
+
+func TestAnalyzer_Inheritance(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi extends Api {
+		let threshold = 5;
+		assert usage => usage > limit;
+	}
+	abstract constraint Api extends Root {
+		let limit = threshold * 2;
+	}
+	abstract constraint Root {
+		let threshold = 2;
+		assert token => token > threshold;
+	}`)
+	if len(analyzer2.Diagnostics) != 0 {
+		t.Fatalf("Analyze() reported %v", analyzer2.Diagnostics)
+	}
+
+	stmt := analyzer2.Stmt[0].(v2.ConstraintStmt)
+	var lets []string
+	for _, let := range stmt.LetStmts {
+		lets = append(lets, v2.PrefixTraversal(let.Expr))
+	}
+	// the child overrides threshold in place, before limit is computed from it
+	if expected := []string{"5", "(* threshold 2)"}; !slices.Equal(lets, expected) {
+		t.Errorf("LetStmts = %v, want %v", lets, expected)
+	}
+	var asserts []string
+	for _, assert := range stmt.AssertStmts {
+		asserts = append(asserts, assert.Id.Literal)
+	}
+	if expected := []string{"token", "usage"}; !slices.Equal(asserts, expected) {
+		t.Errorf("AssertStmts = %v, want %v", asserts, expected)
+	}
+}
+
+func TestAnalyzer_InheritanceErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`constraint A extends Missing;`, []string{v2.UnknownConstraint}},
+		{`constraint Root { assert x => x > 1; }
+		constraint A extends Root;`, []string{v2.ConcreteParent}},
+		{`abstract constraint A extends B { }
+		abstract constraint B extends A { }
+		constraint C extends A;`, []string{v2.InheritanceCycle, v2.InheritanceCycle}},
+		{`abstract constraint A { }
+		constraint A extends A;`, []string{v2.DuplicateIdentifier, v2.InheritanceCycle}},
+	}
+	for _, test := range tests {
+		analyzer2 := analyze(t, test.input)
+		if got := codes(analyzer2.Diagnostics); !slices.Equal(got, test.expected) {
+			t.Errorf("Analyze(%q) reported %v, want %v", test.input, analyzer2.Diagnostics, test.expected)
+		}
+	}
+}
+
+func TestAnalyzer_NestedScopes(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi {
+		assert extra_info as info => {
+			assert name => name > info;
+			assert age => age > name;
+		};
+		assert address => {
+			assert zip => zip > age;
+		};
+	}`)
+	// siblings are in scope, but age is a field of extra_info and out of scope in the assert on zip
+	if got, expected := codes(analyzer2.Diagnostics), []string{v2.UndeclaredIdentifier}; !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_IsExpr(t *testing.T) {
+	analyzer2 := analyze(t, `let x = 10;
+	let e = x is empty;
+	constraint RegisterApi {
+		assert id => id is int;
+		assert usage => usage is not empty;
+	}`)
+	if got, expected := codes(analyzer2.Diagnostics), []string{v2.TypeMismatch, v2.UnknownType}; !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_Literals(t *testing.T) {
+	analyzer2 := analyze(t, `let big = 1_000 * 0xFF + 2.5e3;
+	let flag = true and not false;
+	let none = null;
+	let ordered = none > 1;
+	let sum = true + 1;
+	constraint RegisterApi {
+		assert referrer => referrer != null and flag;
+	}`)
+	if got, expected := codes(analyzer2.Diagnostics), []string{v2.TypeMismatch, v2.TypeMismatch}; !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_FieldPaths(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi {
+		assert user.address.zip => zip > 0 and address is not null and user is not null;
+		assert "content-type" => ct == "json";
+		assert other => address is null;
+	}`)
+	if got, expected := codes(analyzer2.Diagnostics), []string{v2.UndeclaredIdentifier, v2.UndeclaredIdentifier}; !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_StringExpr(t *testing.T) {
+	analyzer2 := analyze(t, `let prefix = "api" + "_";
+	let greeting = "hello " + 1;
+	let ordered = "a" < "b";
+	constraint RegisterApi {
+		assert token => token startsWith prefix and token matches `+"`^[a-z_]+$`"+`;
+		assert phone => phone matches "[0-9";
+		assert count => 10 contains count;
+	}`)
+	expected := []string{v2.TypeMismatch, v2.InvalidPattern, v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_CallExpr(t *testing.T) {
+	analyzer2 := analyze(t, `let small = min(1, 2.5) + abs(-3) + len("abc");
+	let upper = upper(1);
+	let few = max(1);
+	let many = len("a", "b");
+	let unknown = lowr("a");
+	let concat = len("a") + "b";
+	constraint RegisterApi {
+		assert name => len(name) <= 64 and lower(name) != "admin" and now() > 0;
+	}`)
+	expected := []string{v2.TypeMismatch, v2.ArgumentCount, v2.ArgumentCount, v2.UnknownFunction, v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[3].Fix; fix == nil || fix.Replacement != "lower" {
+		t.Errorf("Diagnostics[3].Fix = %v, want replacement lower", fix)
+	}
+}
+
+func TestAnalyzer_ListExpr(t *testing.T) {
+	analyzer2 := analyze(t, `enum Currency { "EUR", "USD" }
+	enum Mixed { 1, "one" }
+	let levels = [1, 2.5];
+	constraint RegisterApi {
+		assert currency => currency in Currency and currency not in ["GBP"];
+		assert level => level in levels;
+		assert count => count > 0 and 1 in Currency;
+		assert name => name in "abc";
+	}`)
+	expected := []string{v2.TypeMismatch, v2.TypeMismatch, v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_CrossField(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi {
+		assert user.address.zip => zip != city;
+		assert user.address.city => city != zip;
+		assert password => password != usrname;
+		assert username => username != password;
+		assert (start, end, start) => start < end and end < deadline;
+	}`)
+	expected := []string{v2.UndeclaredIdentifier, v2.DuplicateIdentifier, v2.UndeclaredIdentifier}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[0].Fix; fix == nil || fix.Replacement != "username" {
+		t.Errorf("Diagnostics[0].Fix = %v, want replacement username", fix)
+	}
+}
+
+func TestAnalyzer_WhenStmt(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi {
+		assert kind => kind in ["card", "bank"];
+		when kind == "card" and card_number != iban {
+			assert card_number => card_number != kind;
+		}
+		when len(kind) {
+			assert iban => iban != card_number;
+		}
+		// type is a field of the request which is only tested by the guards
+		when type == "bank" and amount > 0 {
+			assert required iban;
+		}
+		when type == "card" {
+			assert required card_number;
+		}
+	}`)
+	expected := []string{v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_Quantifiers(t *testing.T) {
+	analyzer2 := analyze(t, `let limit = 10;
+	constraint RegisterApi {
+		assert items each (i) => { i.qty > 0 and count(items) <= limit; i.sku != x; };
+		assert tags (t) all (t) => len(t) < 20;
+		assert ids => ids unique and limit.max > 0;
+		assert name => count("abc") > 0 and "abc" unique;
+	}`)
+	expected := []string{v2.UndeclaredIdentifier, v2.DuplicateIdentifier, v2.TypeMismatch, v2.TypeMismatch, v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Errorf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+}
+
+func TestAnalyzer_Declarations(t *testing.T) {
+	analyzer2 := analyze(t, `constraint RegisterApi {
+		assert email: string format email => email contains "@" and len(email) < 255;
+		assert id: uuid => id != "" and id > 0;
+		assert age: integer => age >= 18 and age is not empty;
+		assert name: text;
+		assert site: string format ur1;
+		assert count: integer format date;
+		assert tags: list => count(tags) < 10 and tags is not empty;
+	}`)
+	expected := []string{v2.TypeMismatch, v2.TypeMismatch, v2.UnknownType, v2.UnknownFormat, v2.TypeMismatch}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[3].Fix; fix == nil || fix.Replacement != "uri" {
+		t.Errorf("Diagnostics[3].Fix = %v, want replacement uri", fix)
+	}
+}
+
+func TestAnalyzer_Modifiers(t *testing.T) {
+	analyzer2 := analyze(t, `let limit = 10;
+	constraint ListApi {
+		assert required id: uuid default 1;
+		assert optional page: integer default limit * 2 => page > 0;
+		assert ratio: float default 1;
+		assert cursor: string default null;
+		assert nullable after: string default null;
+		assert required optional size;
+		assert nullable nullable sort default page;
+		assert user => { assert optional offset default user; }
+	}`)
+	expected := []string{v2.TypeMismatch, v2.TypeMismatch, v2.ConflictingModifiers, v2.ConflictingModifiers, v2.UndeclaredIdentifier, v2.UndeclaredIdentifier}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[1].Fix; fix == nil || fix.Replacement != "nullable cursor" {
+		t.Errorf("Diagnostics[1].Fix = %v, want replacement nullable cursor", fix)
+	}
+}
+
+func TestAnalyzer_FieldModifiers(t *testing.T) {
+	analyzer2 := analyze(t, `abstract constraint Api {
+		assert required page: integer;
+		assert optional limit: integer default 10;
+	}
+	constraint ListApi extends Api {
+		assert optional page => page > 0;
+		assert limit default 20;
+		assert user => { assert required name: string; }
+		assert optional user.name;
+		assert optional cursor;
+		when page > 1 { assert required cursor; assert required limit; }
+	}`)
+	expected := []string{
+		"[6:10] E019 Field page cannot be both required and optional",
+		"[7:24] E019 Field limit already has a default",
+		"[9:10] E019 Field name cannot be both required and optional",
+	}
+	if len(analyzer2.Diagnostics) != len(expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	for i, d := range analyzer2.Diagnostics {
+		if d.Error() != expected[i] {
+			t.Errorf("Diagnostics[%d] = %q, want %q", i, d, expected[i])
+		}
+	}
+	if note := analyzer2.Diagnostics[1].Notes; len(note) != 1 || note[0] != "previously defaulted at 3:42" {
+		t.Errorf("Diagnostics[1].Notes = %v, want previously defaulted at 3:42", note)
+	}
+}
+
+func TestAnalyzer_Violations(t *testing.T) {
+	analyzer2 := analyze(t, `let threshold = 40;
+	enum Scope { "read", "write" }
+	constraint TokenApi {
+		assert t => t > threshold else "token must exceed {threshold}" code "TOKEN_TOO_SMALL";
+		assert scope => scope in Scope else "scope must be one of {Scope}, {{not a placeholder}";
+		assert limit => limit > 0 else "limit must exceed {treshold}";
+		assert user => {
+			assert name => name != "" else "{user} needs a name";
+		}
+	}`)
+	expected := []string{v2.UndeclaredIdentifier, v2.UndeclaredIdentifier}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[0].Fix; fix == nil || fix.Replacement != `"limit must exceed {threshold}"` {
+		t.Errorf("Diagnostics[0].Fix = %v, want replacement with {threshold}", fix)
+	}
+}
+
+func TestAnalyzer_Annotations(t *testing.T) {
+	analyzer2 := analyze(t, `let release = "2024-03";
+	@since(release)
+	@depreciated("use v2")
+	@owner("payments")
+	@severity(error)
+	constraint PayApi {
+		@example(42)
+		@example(43)
+		assert amount => amount > 0;
+		@owner("payments")
+		@since(2024)
+		assert currency => currency != "";
+		@severity(warnings)
+		@deprecated
+		@example(amount)
+		assert fee => fee >= 0;
+		@example("abc")
+		assert age: integer;
+		@example(1) assert ratio: float;
+		@example(null) assert nullable note: string;
+	}`)
+	expected := []string{
+		v2.UnknownAnnotation, v2.InvalidAnnotation, v2.InvalidAnnotation, v2.InvalidAnnotation,
+		v2.TypeMismatch, v2.InvalidAnnotation, v2.ArgumentCount, v2.UndeclaredIdentifier, v2.TypeMismatch,
+	}
+	if got := codes(analyzer2.Diagnostics); !slices.Equal(got, expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	if fix := analyzer2.Diagnostics[0].Fix; fix == nil || fix.Replacement != "@deprecated" {
+		t.Errorf("Diagnostics[0].Fix = %v, want replacement @deprecated", fix)
+	}
+	if fix := analyzer2.Diagnostics[5].Fix; fix == nil || fix.Replacement != "warning" {
+		t.Errorf("Diagnostics[5].Fix = %v, want replacement warning", fix)
+	}
+}
+
+func TestAnalyzer_FieldKeys(t *testing.T) {
+	analyzer2 := analyze(t, `abstract constraint Api {
+		assert user_id => user_id > 0;
+	}
+	constraint UserApi extends Api {
+		assert userId => userId > 0;
+		assert user.first_name: string;
+		assert user => { assert firstName => firstName != ""; assert first_name => first_name != ""; }
+		assert _ => _ > 0;
+		assert "-" (d) => d > 0;
+		assert (content_type, contentType) => content_type != contentType;
+	}`)
+	expected := []string{
+		"[5:10] E022 Fields user_id and userId are both rendered as UserId",
+		"[7:27] E022 Fields first_name and firstName are both rendered as FirstName",
+		"[8:10] E022 Field name _ cannot be rendered, it has no letter or digit",
+		"[9:10] E022 Field name \"-\" cannot be rendered, it has no letter or digit",
+		"[10:25] E022 Fields content_type and contentType are both rendered as ContentType",
+	}
+	if len(analyzer2.Diagnostics) != len(expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	for i, d := range analyzer2.Diagnostics {
+		if d.Error() != expected[i] {
+			t.Errorf("Diagnostics[%d] = %q, want %q", i, d, expected[i])
+		}
+	}
+}
+
+func TestAnalyzer_DeclaredTypes(t *testing.T) {
+	analyzer2 := analyze(t, `abstract constraint Api {
+		assert id: integer;
+		assert expires: date-time;
+	}
+	constraint UserApi extends Api {
+		assert id: string;
+		assert age: integer;
+		assert age: integer => age >= 18;
+		assert name: string => name != age;
+		assert limit => limit < age and age > "x";
+		assert expires (e) => e > now() + 60;
+		assert starts: string format date-time => starts <= now() and starts < expires;
+		assert token: uuid => token > now();
+	}`)
+	expected := []string{
+		"[6:14] E006 Type mismatch, field id is already declared as integer, found string",
+		"[9:31] E006 Type mismatch, cannot apply '!=' to String and Integer",
+		"[10:39] E006 Type mismatch, cannot apply '>' to Integer and String",
+		"[13:31] E006 Type mismatch, cannot apply '>' to String and Integer",
+	}
+	if len(analyzer2.Diagnostics) != len(expected) {
+		t.Fatalf("Analyze() reported %v, want %v", analyzer2.Diagnostics, expected)
+	}
+	for i, d := range analyzer2.Diagnostics {
+		if d.Error() != expected[i] {
+			t.Errorf("Diagnostics[%d] = %q, want %q", i, d, expected[i])
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package ast
+
+// AnnotationSpec is the signature of a known annotation and the statements it may be attached to.
+// An annotation with Choices takes one of them as its single argument, written as an identifier.
+type AnnotationSpec struct {
+	Params     []LiteralType
+	Choices    []string
+	Constraint bool
+	Assert     bool
+}
+
+// Annotations registers the annotations which can be attached to constraints and asserts, engine.Generator renders them.
+var Annotations = map[string]AnnotationSpec{
+	"deprecated": {Params: []LiteralType{String}, Constraint: true, Assert: true},
+	"since":      {Params: []LiteralType{String}, Constraint: true, Assert: true},
+	// owner is the team answering for the requests of a constraint
+	"owner": {Params: []LiteralType{String}, Constraint: true},
+	// severity is that of the violations of an assert
+	"severity": {Choices: []string{"error", "warning", "info"}, Assert: true},
+	// example is a valid value of the asserted field
+	"example": {Params: []LiteralType{Any}, Assert: true},
+}
@@ -0,0 +1,35 @@
+package ast
+
+// Signature is the type of a builtin function. A parameter of type Any accepts every value and one
+// of type Float accepts every number, the last parameter of a variadic function may be repeated.
+type Signature struct {
+	Params   []LiteralType
+	Variadic bool
+	Result   LiteralType
+	// Numeric results are integers when every argument is an integer, and floats otherwise
+	Numeric bool
+}
+
+// Builtins registers the functions which can be called in expressions, engine.Functions folds them.
+var Builtins = map[string]Signature{
+	"len":   {Params: []LiteralType{String}, Result: Integer},
+	"count": {Params: []LiteralType{List}, Result: Integer},
+	"lower": {Params: []LiteralType{String}, Result: String},
+	"upper": {Params: []LiteralType{String}, Result: String},
+	"abs":   {Params: []LiteralType{Float}, Result: Float, Numeric: true},
+	"min":   {Params: []LiteralType{Float, Float}, Variadic: true, Result: Float, Numeric: true},
+	"max":   {Params: []LiteralType{Float, Float}, Variadic: true, Result: Float, Numeric: true},
+	// now is the time of the request in seconds since the Unix epoch, it is only known at runtime
+	"now": {Result: Integer},
+}
+
+// Accepts reports whether an argument of type arg can be passed to a parameter of type param.
+func (r Signature) Accepts(param, arg LiteralType) bool {
+	switch {
+	case param == Any || arg == Any || param == arg:
+		return true
+	case param == Float:
+		return arg == Integer
+	}
+	return false
+}
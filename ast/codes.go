@@ -0,0 +1,338 @@
+package ast
+
+import "sort"
+
+const (
+	ImplicitRequestDefinition = "W001"
+	UnrenderedAssertion       = "W002"
+
+	InvalidConstraint    = "E001"
+	InvalidToken         = "E002"
+	UnexpectedToken      = "E003"
+	DuplicateIdentifier  = "E004"
+	UndeclaredIdentifier = "E005"
+	TypeMismatch         = "E006"
+	UnknownConstraint    = "E007"
+	ConcreteParent       = "E008"
+	InheritanceCycle     = "E009"
+	UnknownType          = "E010"
+	UnterminatedComment  = "E011"
+	UnterminatedString   = "E012"
+	InvalidEscape        = "E013"
+	MalformedNumber      = "E014"
+	InvalidPattern       = "E015"
+	UnknownFunction      = "E016"
+	ArgumentCount        = "E017"
+	UnknownFormat        = "E018"
+	ConflictingModifiers = "E019"
+	UnknownAnnotation    = "E020"
+	InvalidAnnotation    = "E021"
+	InvalidFieldName     = "E022"
+)
+
+type CodeInfo struct {
+	Code        string
+	Severity    Severity
+	Title       string
+	Explanation string
+}
+
+// Codes registers every diagnostic the compiler can report, blueprint.md documents the same catalog.
+var Codes = map[string]CodeInfo{
+	ImplicitRequestDefinition: {
+		Code:     ImplicitRequestDefinition,
+		Severity: SeverityWarning,
+		Title:    "implicit request definition",
+		Explanation: `This warning is shown when the constraint is not having any request definition.
+This is a warning because it is not a good practice to have a constraint without a request definition.
+It is recommended to have a request definition for the constraint.
+
+    constraint RegisterApi {
+        let threshold = 10;
+    }
+
+Add at least one assert statement describing a field of the request.`,
+	},
+	UnrenderedAssertion: {
+		Code:     UnrenderedAssertion,
+		Severity: SeverityWarning,
+		Title:    "unrendered assertion",
+		Explanation: `An assertion cannot be rendered as rules of the schema and is left out of it:
+
+    assert total => total > price * quantity;
+
+A rule compares the asserted field, its length or its count with a value known at compile time,
+with another field of the same object, or with the time of the request as now() plus or minus a number
+of seconds. Conditions combined with 'and' are rendered one by one. The asserts of a 'when' are left out
+along with their guard when the guard cannot be rendered.`,
+	},
+	InvalidConstraint: {
+		Code:     InvalidConstraint,
+		Severity: SeverityError,
+		Title:    "invalid constraint",
+		Explanation: `The constraint declaration is malformed. A constraint is written as
+
+    [abstract] constraint Name [extends Parent] { ... }
+
+with a name, an optional parent and a body enclosed in braces.`,
+	},
+	InvalidToken: {
+		Code:     InvalidToken,
+		Severity: SeverityError,
+		Title:    "invalid token",
+		Explanation: `The lexer found a character which does not start any token of the language,
+for example '$' or '#'. Remove the character or write it inside a string.`,
+	},
+	UnexpectedToken: {
+		Code:     UnexpectedToken,
+		Severity: SeverityError,
+		Title:    "unexpected token",
+		Explanation: `The parser found a token where the grammar does not allow it, for example a
+missing semicolon at the end of a let statement:
+
+    let x = 10
+
+The parser skips to the end of the statement and carries on, so every syntax error
+of a file is reported in a single run.`,
+	},
+	DuplicateIdentifier: {
+		Code:     DuplicateIdentifier,
+		Severity: SeverityError,
+		Title:    "duplicate identifier",
+		Explanation: `A name is declared twice in the same scope:
+
+    let x = 1;
+    let x = 2;
+
+Rename one of the declarations.`,
+	},
+	UndeclaredIdentifier: {
+		Code:     UndeclaredIdentifier,
+		Severity: SeverityError,
+		Title:    "undeclared identifier",
+		Explanation: `An expression refers to a name which is neither a let statement in scope, nor the
+field or alias of the enclosing assert statement, nor another field asserted in the same object:
+
+    assert token as t => t > threshold;
+
+Declare the name with a let statement before using it, or assert the field it refers to.
+The placeholders of a violation message are replaced at compile time and may only name a let:
+
+    assert token as t => t > threshold else "token must exceed {threshold}";`,
+	},
+	TypeMismatch: {
+		Code:     TypeMismatch,
+		Severity: SeverityError,
+		Title:    "type mismatch",
+		Explanation: `The operands of an operator have incompatible types, for example adding a string
+to an integer or combining integers with 'and':
+
+    let x = "10" + 2;
+
+Arithmetic requires numbers and logical operators require booleans.`,
+	},
+	UnknownConstraint: {
+		Code:     UnknownConstraint,
+		Severity: SeverityError,
+		Title:    "unknown constraint",
+		Explanation: `A constraint extends a name which is not declared by any constraint of the file:
+
+    constraint RegisterApi extends Root;
+
+Declare the parent with 'abstract constraint Root { ... }' or fix its name.`,
+	},
+	ConcreteParent: {
+		Code:     ConcreteParent,
+		Severity: SeverityError,
+		Title:    "concrete parent constraint",
+		Explanation: `Only abstract constraints can be extended. A concrete constraint describes a
+request of its own and is rendered in the generated schema, so it cannot serve as a base:
+
+    constraint Root { ... }
+    constraint RegisterApi extends Root;
+
+Declare the parent as 'abstract constraint Root'.`,
+	},
+	InheritanceCycle: {
+		Code:     InheritanceCycle,
+		Severity: SeverityError,
+		Title:    "inheritance cycle",
+		Explanation: `A constraint inherits from itself through its chain of parents:
+
+    abstract constraint A extends B { ... }
+    abstract constraint B extends A { ... }
+
+Break the cycle by removing one of the extends clauses.`,
+	},
+	UnknownType: {
+		Code:     UnknownType,
+		Severity: SeverityError,
+		Title:    "unknown type",
+		Explanation: `A type test names a type the language does not know:
+
+    assert id => id is int;
+
+The known types are integer, float, string, boolean and list. A field declaration may also
+name a format as its type, 'assert id: uuid' declares a string in the uuid format.`,
+	},
+	UnterminatedComment: {
+		Code:     UnterminatedComment,
+		Severity: SeverityError,
+		Title:    "unterminated comment",
+		Explanation: `A block comment is opened with '/*' but never closed, so the rest of the file
+is swallowed by the comment:
+
+    /* the threshold of the token
+    let threshold = 10;
+
+Close the comment with '*/'.`,
+	},
+	UnterminatedString: {
+		Code:     UnterminatedString,
+		Severity: SeverityError,
+		Title:    "unterminated string",
+		Explanation: `A string is opened with '"' or '` + "`" + `' but never closed, so the rest of the file
+is swallowed by the string:
+
+    let message = "the token is too short;
+
+Close the string with the quote it was opened with.`,
+	},
+	InvalidEscape: {
+		Code:     InvalidEscape,
+		Severity: SeverityError,
+		Title:    "invalid escape sequence",
+		Explanation: `A backslash in a double quoted string starts an escape sequence which the
+language does not know:
+
+    let pattern = "\d+";
+
+The known escapes are \n, \r, \t, \\, \", \xHH, \uHHHH and \UHHHHHHHH, \xHH only goes up to \x7F
+since a single byte above it is not a character, write \u00FF rather than \xFF. Write the
+backslash as \\ or use a raw string, which is enclosed in backticks and keeps every
+character as written:
+
+    let pattern = ` + "`\\d+`" + `;`,
+	},
+	MalformedNumber: {
+		Code:     MalformedNumber,
+		Severity: SeverityError,
+		Title:    "malformed number",
+		Explanation: `A number is not written in any of the supported notations, or does not fit in
+64 bits:
+
+    let limit = 1.2.3;
+
+Numbers are written as 42, 1_000_000, 0xFF, 2.5 or 1e-3. An underscore may only
+separate two digits.`,
+	},
+	InvalidPattern: {
+		Code:     InvalidPattern,
+		Severity: SeverityError,
+		Title:    "invalid pattern",
+		Explanation: `The right operand of 'matches' is not a valid regular expression:
+
+    assert phone => phone matches "[0-9";
+
+Patterns follow the RE2 syntax. Write them as raw strings enclosed in backticks, so
+that backslashes do not need to be escaped.`,
+	},
+	UnknownFunction: {
+		Code:     UnknownFunction,
+		Severity: SeverityError,
+		Title:    "unknown function",
+		Explanation: `An expression calls a function which is not a builtin:
+
+    assert name => length(name) <= 64;
+
+The builtin functions are abs, count, len, lower, max, min, now and upper.`,
+	},
+	ArgumentCount: {
+		Code:     ArgumentCount,
+		Severity: SeverityError,
+		Title:    "wrong number of arguments",
+		Explanation: `A builtin function is called with too many or too few arguments:
+
+    let smallest = min(1);
+
+len, count, lower, upper and abs take one argument, min and max at least two and now none.
+The same holds for annotations, @deprecated, @since, @owner, @severity and @example take one argument.`,
+	},
+	UnknownFormat: {
+		Code:     UnknownFormat,
+		Severity: SeverityError,
+		Title:    "unknown format",
+		Explanation: `A field declaration names a format the language does not know:
+
+    assert email: string format mail;
+
+The known formats are date, date-time, email, hostname, ipv4, ipv6, uri and uuid.
+A format only applies to strings.`,
+	},
+	ConflictingModifiers: {
+		Code:     ConflictingModifiers,
+		Severity: SeverityError,
+		Title:    "conflicting modifiers",
+		Explanation: `A field is marked both required and optional, or is marked twice with the same modifier, or is given
+more than one default. The asserts of a constraint and of its parents describe the same fields:
+
+    assert required optional email;
+    assert required page;
+    assert optional page default 1;
+
+Keep the modifier and the default which describe the field.`,
+	},
+	UnknownAnnotation: {
+		Code:     UnknownAnnotation,
+		Severity: SeverityError,
+		Title:    "unknown annotation",
+		Explanation: `A constraint or an assert is annotated with a name the compiler does not know:
+
+    @depreciated("use v2")
+    constraint TokenApi { ... }
+
+The known annotations are @deprecated, @example, @owner, @severity and @since.`,
+	},
+	InvalidAnnotation: {
+		Code:     InvalidAnnotation,
+		Severity: SeverityError,
+		Title:    "invalid annotation",
+		Explanation: `An annotation is attached to a statement it does not describe, is repeated,
+or its argument is not one of the accepted values:
+
+    @owner("payments")
+    assert amount => amount > 0;
+
+@owner only applies to constraints, @severity and @example only to asserts and @severity
+takes one of error, warning or info.`,
+	},
+	InvalidFieldName: {
+		Code:     InvalidFieldName,
+		Severity: SeverityError,
+		Title:    "invalid field name",
+		Explanation: `A field name cannot be rendered as a key of the generated schema. Names are written in
+PascalCase, so two fields of the same object whose names only differ by case or separators
+would share a key, and a name made of separators only has none:
+
+    assert user_id => user_id > 0;
+    assert userId => userId > 0;
+
+Refer to each field of the request by a single spelling.`,
+	},
+}
+
+// Explain looks up a code of the catalog.
+func Explain(code string) (CodeInfo, bool) {
+	info, ok := Codes[code]
+	return info, ok
+}
+
+// KnownCodes returns every registered code in order.
+func KnownCodes() []string {
+	codes := make([]string, 0, len(Codes))
+	for code := range Codes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
@@ -3,12 +3,166 @@ package ast
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
 func Debug(line, col int) string {
 	return fmt.Sprintf("[%d:%d]", line, col)
 }
 
-func InvalidTokenErr(line, col int) error {
-	return errors.New(fmt.Sprintf(Debug(line, col) + " Invalid token"))
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (r Severity) String() string {
+	switch r {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "Undefined"
+}
+
+// Span is the source range a diagnostic points at, End is exclusive.
+type Span struct {
+	Start DebugInfo
+	End   DebugInfo
+}
+
+func TokenSpan(token Token) Span {
+	end := token.DebugInfo
+	if token.TokenType == Eof {
+		return Span{Start: token.DebugInfo, End: end}
+	}
+	text := token.Text()
+	end.Offset += len(text)
+	// a multi-line string ends on a later line
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		end.Line += strings.Count(text, "\n")
+		end.Column = 1 + utf8.RuneCountInString(text[i+1:])
+	} else {
+		end.Column += utf8.RuneCountInString(text)
+	}
+	return Span{Start: token.DebugInfo, End: end}
+}
+
+// ExprSpan covers an expression from its leftmost to its rightmost token.
+func ExprSpan(expr Expr) Span {
+	switch v := expr.(type) {
+	case Token:
+		return TokenSpan(v)
+	case UnaryExpr:
+		return Span{Start: v.Op.DebugInfo, End: ExprSpan(v.Expr).End}
+	case BinaryExpr:
+		return Span{Start: ExprSpan(v.Left).Start, End: ExprSpan(v.Right).End}
+	case IsExpr:
+		return Span{Start: ExprSpan(v.Expr).Start, End: TokenSpan(v.Predicate).End}
+	case CallExpr:
+		return Span{Start: v.Callee.DebugInfo, End: TokenSpan(v.RightParen).End}
+	case ListExpr:
+		return Span{Start: v.LeftBracket.DebugInfo, End: TokenSpan(v.RightBracket).End}
+	case MemberExpr:
+		return Span{Start: ExprSpan(v.Expr).Start, End: TokenSpan(v.Name).End}
+	case UniqueExpr:
+		if v.By.Literal != "" {
+			return Span{Start: ExprSpan(v.Expr).Start, End: TokenSpan(v.By).End}
+		}
+		return Span{Start: ExprSpan(v.Expr).Start, End: TokenSpan(v.Op).End}
+	}
+	return Span{}
+}
+
+// AnnotationSpan covers the name of an annotation, from its '@'.
+func AnnotationSpan(annotation Annotation) Span {
+	return Span{Start: annotation.At.DebugInfo, End: TokenSpan(annotation.Name).End}
+}
+
+// Fix is a suggested edit which replaces the text of Span with Replacement.
+type Fix struct {
+	Message     string
+	Span        Span
+	Replacement string
+}
+
+// Diagnostic is an error or a warning reported by the lexer, the parser or the analyzer.
+// Tools embedding the compiler can branch on the code with errors.As or errors.Is.
+type Diagnostic struct {
+	Code     string
+	Severity Severity
+	Message  string
+	Span     Span
+	Notes    []string
+	Fix      *Fix
+}
+
+// NewDiagnostic creates a diagnostic with the severity registered for the code.
+func NewDiagnostic(code string, span Span, format string, args ...any) Diagnostic {
+	return Diagnostic{Code: code, Severity: Codes[code].Severity, Message: fmt.Sprintf(format, args...), Span: span}
+}
+
+func (r Diagnostic) WithNote(note string) Diagnostic {
+	r.Notes = append(append([]string(nil), r.Notes...), note)
+	return r
+}
+
+func (r Diagnostic) WithFix(fix Fix) Diagnostic {
+	r.Fix = &fix
+	return r
+}
+
+func (r Diagnostic) Error() string {
+	return fmt.Sprintf("%s %s %s", Debug(r.Span.Start.Line, r.Span.Start.Column), r.Code, r.Message)
+}
+
+// Is matches any diagnostic carrying the same code, so errors.Is(err, Diagnostic{Code: TypeMismatch}) holds
+// for every type mismatch.
+func (r Diagnostic) Is(target error) bool {
+	var d Diagnostic
+	return errors.As(target, &d) && d.Code == r.Code
+}
+
+type Diagnostics []Diagnostic
+
+func (r Diagnostics) HasErrors() bool {
+	for _, d := range r {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err joins the error diagnostics, warnings alone do not make a failure.
+func (r Diagnostics) Err() error {
+	var errs []error
+	for _, d := range r {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func InvalidTokenErr(at DebugInfo, char rune) Diagnostic {
+	end := at
+	end.Column += 1
+	end.Offset += max(utf8.RuneLen(char), 1)
+	return NewDiagnostic(InvalidToken, Span{Start: at, End: end}, "Invalid token %q", char)
+}
+
+func ExpectedErr(code string, token Token, expected string) Diagnostic {
+	return NewDiagnostic(code, TokenSpan(token), "Expected %s, found %s", expected, Describe(token))
+}
+
+// Describe names a token the way it is written in the source.
+func Describe(token Token) string {
+	if token.TokenType == Eof {
+		return "end of file"
+	}
+	return "'" + token.Text() + "'"
 }
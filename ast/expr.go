@@ -3,6 +3,11 @@ package ast
 type ExprVisitor interface {
 	VisitBinaryExpr(BinaryExpr) LiteralType
 	VisitUnaryExpr(UnaryExpr) LiteralType
+	VisitIsExpr(IsExpr) LiteralType
+	VisitCallExpr(CallExpr) LiteralType
+	VisitListExpr(ListExpr) LiteralType
+	VisitMemberExpr(MemberExpr) LiteralType
+	VisitUniqueExpr(UniqueExpr) LiteralType
 	VisitToken(Token) LiteralType
 }
 
@@ -29,6 +34,119 @@ func (r UnaryExpr) Accept(v ExprVisitor) LiteralType {
 	return v.VisitUnaryExpr(r)
 }
 
+// IsExpr tests a property of a value, such as `usage is not empty` or `id is integer`.
+// Predicate is the Empty or Null keyword, or the identifier of a type.
+type IsExpr struct {
+	Expr      Expr
+	Op        Token
+	Not       bool
+	Predicate Token
+}
+
+func (r IsExpr) Accept(v ExprVisitor) LiteralType {
+	return v.VisitIsExpr(r)
+}
+
+// CallExpr calls a builtin function such as `len(name)`, RightParen closes the arguments.
+type CallExpr struct {
+	Callee     Token
+	Args       []Expr
+	RightParen Token
+}
+
+func (r CallExpr) Accept(v ExprVisitor) LiteralType {
+	return v.VisitCallExpr(r)
+}
+
+// ListExpr is a list literal such as `["active", "pending"]`, its elements share a type.
+type ListExpr struct {
+	LeftBracket  Token
+	Elements     []Expr
+	RightBracket Token
+}
+
+func (r ListExpr) Accept(v ExprVisitor) LiteralType {
+	return v.VisitListExpr(r)
+}
+
+// MemberExpr refers to a field of an object, such as `i.qty` for the element i of a list.
+type MemberExpr struct {
+	Expr Expr
+	Name Token
+}
+
+func (r MemberExpr) Accept(v ExprVisitor) LiteralType {
+	return v.VisitMemberExpr(r)
+}
+
+// UniqueExpr tests that the elements of a list are distinct, `ids unique`, or that a field of
+// its elements is, `items unique by id`. By is empty in the first form.
+type UniqueExpr struct {
+	Expr Expr
+	Op   Token
+	By   Token
+}
+
+func (r UniqueExpr) Accept(v ExprVisitor) LiteralType {
+	return v.VisitUniqueExpr(r)
+}
+
+// TypeNames maps the type names of `is` tests and field declarations to the type they stand for.
+var TypeNames = map[string]LiteralType{
+	"integer": Integer,
+	"float":   Float,
+	"string":  String,
+	"boolean": Boolean,
+	"list":    List,
+}
+
+// Formats are the semantic formats of strings, `assert email: string format email`. A format can also be
+// declared as the type of a field, `assert id: uuid` declares a string in the uuid format.
+var Formats = map[string]bool{
+	"email":     true,
+	"uuid":      true,
+	"uri":       true,
+	"ipv4":      true,
+	"ipv6":      true,
+	"date":      true,
+	"date-time": true,
+	"hostname":  true,
+}
+
 func (r Token) Accept(v ExprVisitor) LiteralType {
 	return v.VisitToken(r)
 }
+
+// Idents returns the identifiers an expression refers to in order of appearance, the object of a member access
+// is one of them but not the member itself nor the callee of a call.
+func Idents(expr Expr) []Token {
+	switch v := expr.(type) {
+	case Token:
+		if v.TokenType == Ident {
+			return []Token{v}
+		}
+	case BinaryExpr:
+		return append(Idents(v.Left), Idents(v.Right)...)
+	case UnaryExpr:
+		return Idents(v.Expr)
+	case IsExpr:
+		return Idents(v.Expr)
+	case MemberExpr:
+		return Idents(v.Expr)
+	case UniqueExpr:
+		return Idents(v.Expr)
+	case CallExpr:
+		var idents []Token
+		for _, arg := range v.Args {
+			idents = append(idents, Idents(arg)...)
+		}
+		return idents
+	case ListExpr:
+		var idents []Token
+		for _, e := range v.Elements {
+			idents = append(idents, Idents(e)...)
+		}
+		return idents
+	}
+	return nil
+}
@@ -2,12 +2,18 @@ package parser
 
 import (
 	"customs/ast"
+	"errors"
+	"fmt"
 	"slices"
 )
 
 type Parser struct {
-	Token   []ast.Token
-	current int
+	Token       []ast.Token
+	Diagnostics ast.Diagnostics
+	// Comments holds the comments after the last statement
+	Comments []ast.Comment
+	current  int
+	trivia   []ast.Comment
 }
 
 func NewParser(tokens []ast.Token) Parser {
@@ -15,6 +21,9 @@ func NewParser(tokens []ast.Token) Parser {
 }
 
 func (r *Parser) This() ast.Token {
+	if r.current >= len(r.Token) {
+		return r.Token[len(r.Token)-1]
+	}
 	return r.Token[r.current]
 }
 
@@ -23,11 +32,25 @@ func (r *Parser) Prev() ast.Token {
 }
 
 func (r *Parser) Peek() ast.Token {
+	if r.current+1 >= len(r.Token) {
+		return r.Token[len(r.Token)-1]
+	}
 	return r.Token[r.current+1]
 }
 
 func (r *Parser) Advance() {
-	r.current += 1
+	if !r.IsAtEnd() {
+		r.trivia = append(r.trivia, r.This().Trivia...)
+		r.current += 1
+	}
+}
+
+// TakeComments returns the comments of the tokens consumed since the last call, comments inside
+// an expression are moved to the next statement rather than dropped.
+func (r *Parser) TakeComments() []ast.Comment {
+	comments := r.trivia
+	r.trivia = nil
+	return comments
 }
 
 func (r *Parser) IsValue() bool {
@@ -39,7 +62,7 @@ func (r *Parser) IsAtEnd() bool {
 }
 
 func (r *Parser) IsKeyword() bool {
-	keywords := []ast.TokenType{ast.Let, ast.Assert, ast.Constraint, ast.Abstract, ast.Extends, ast.Is, ast.Assert}
+	keywords := []ast.TokenType{ast.Let, ast.Assert, ast.Constraint, ast.Abstract, ast.Extends, ast.Is, ast.Enum, ast.When}
 	if slices.Contains(keywords, r.This().TokenType) {
 		return true
 	}
@@ -47,12 +70,8 @@ func (r *Parser) IsKeyword() bool {
 }
 
 func (r *Parser) IsOperator() bool {
-	operators := []ast.TokenType{ast.Plus, ast.Minus, ast.Multiply, ast.Divide, ast.And, ast.Or,
-		ast.GreaterThan, ast.GreaterThanOrEqual, ast.LessThan, ast.LessThanOrEqual, ast.Equal, ast.NotEqual}
-	if slices.Contains(operators, r.This().TokenType) {
-		return true
-	}
-	return false
+	_, ok := Operators[r.TokenType()]
+	return ok
 }
 
 func (r *Parser) TokenType() ast.TokenType {
@@ -68,202 +87,795 @@ func (r *Parser) MatchAndConsume(typ ast.TokenType) (ast.Token, bool) {
 	return ast.Token{}, false
 }
 
+// Expect consumes the current token if it has the given type, otherwise it reports what was expected.
+func (r *Parser) Expect(typ ast.TokenType, expected string) (ast.Token, error) {
+	return r.expect(ast.UnexpectedToken, typ, expected)
+}
+
+func (r *Parser) expect(code string, typ ast.TokenType, expected string) (ast.Token, error) {
+	if token, ok := r.MatchAndConsume(typ); ok {
+		return token, nil
+	}
+	err := ast.ExpectedErr(code, r.This(), expected)
+	if typ == ast.Semicolon && r.current > 0 {
+		end := ast.TokenSpan(r.Prev()).End
+		err = err.WithFix(ast.Fix{Message: "insert ';'", Span: ast.Span{Start: end, End: end}, Replacement: ";"})
+	}
+	return r.This(), err
+}
+
+// optionalSemicolon consumes the semicolon after the closing brace of a block, which may be omitted.
+func (r *Parser) optionalSemicolon() {
+	r.MatchAndConsume(ast.Semicolon)
+}
+
+// report records the diagnostic carried by err.
+func (r *Parser) report(err error) {
+	var d ast.Diagnostic
+	if errors.As(err, &d) {
+		r.Diagnostics = append(r.Diagnostics, d)
+	}
+}
+
+// Synchronize skips the rest of a malformed statement, it stops after a semicolon
+// or before a closing brace or a keyword starting another statement.
+func (r *Parser) Synchronize() {
+	for !r.IsAtEnd() {
+		switch r.TokenType() {
+		case ast.Semicolon:
+			r.Advance()
+			return
+		case ast.RightBrace, ast.Let, ast.Assert, ast.Constraint, ast.Abstract, ast.Enum, ast.When, ast.At:
+			return
+		}
+		r.Advance()
+	}
+}
+
+// Parse parses the whole program, on a syntax error it skips to the next statement
+// so that every error in the source is reported at once.
 func (r *Parser) Parse() (stmts []ast.Stmt, err error) {
+	r.Token = ensureEof(r.Token)
 	for !r.IsAtEnd() {
+		var stmt ast.Stmt
 		switch r.TokenType() {
 		case ast.Let:
-			assign, ok := r.ParseAssignStmt()
-			if !ok {
-				err = ast.InvalidTokenErr(r.This().DebugInfo.Line, r.This().DebugInfo.Column)
-			}
-			stmts = append(stmts, assign)
+			stmt, err = r.ParseAssignStmt()
+		case ast.Enum:
+			stmt, err = r.ParseEnumStmt()
 		case ast.Abstract:
 			r.Advance()
-			constraint, ok := r.ParseConstraintStmt(true)
-			if !ok {
-				err = ast.InvalidTokenErr(r.This().DebugInfo.Line, r.This().DebugInfo.Column)
-			}
-			stmts = append(stmts, constraint)
+			stmt, err = r.ParseConstraintStmt(true)
 		case ast.Constraint:
-			constraint, ok := r.ParseConstraintStmt(false)
-			if !ok {
-				err = ast.InvalidTokenErr(r.This().DebugInfo.Line, r.This().DebugInfo.Column)
-			}
-			stmts = append(stmts, constraint)
+			stmt, err = r.ParseConstraintStmt(false)
+		case ast.At:
+			stmt, err = r.ParseAnnotatedStmt()
 		case ast.Assert:
-			assert, ok := r.ParseAssertStmt()
-			if !ok {
-				err = ast.InvalidTokenErr(r.This().DebugInfo.Line, r.This().DebugInfo.Column)
-			}
-			stmts = append(stmts, assert)
+			stmt, err = r.ParseAssertStmt()
 		default:
-			panic("Invalid token")
+			err = ast.ExpectedErr(ast.UnexpectedToken, r.This(), "a statement")
+			r.Advance()
 		}
+		if err != nil {
+			r.report(err)
+			r.Synchronize()
+			continue
+		}
+		stmts = append(stmts, stmt)
 	}
+	r.Comments = append(r.TakeComments(), r.This().Trivia...)
+	err = r.Diagnostics.Err()
 	return
 }
 
-func (r *Parser) ParseConstraintStmt(prefixAbstract bool) (stmt ast.ConstraintStmt, ok bool) {
-	stmt.IsAbstract = prefixAbstract
-	_, ok = r.MatchAndConsume(ast.Constraint)
-	stmt.Id, ok = r.MatchAndConsume(ast.Ident)
-	if !ok {
-		panic("Expected identifier")
-	}
-	_, ok = r.MatchAndConsume(ast.Extends)
-	if ok {
-		stmt.ParentConstraint = r.This()
+// ParseEnumStmt parses `enum Name { value, ... }`, a trailing comma is allowed.
+func (r *Parser) ParseEnumStmt() (stmt ast.EnumStmt, err error) {
+	if _, err = r.Expect(ast.Enum, "'enum'"); err != nil {
+		return
+	}
+	stmt.Comments = r.TakeComments()
+	if stmt.Id, err = r.Expect(ast.Ident, "enum name"); err != nil {
+		return
+	}
+	if _, err = r.Expect(ast.LeftBrace, "'{'"); err != nil {
+		return
+	}
+	for r.TokenType() != ast.RightBrace {
+		var value ast.Expr
+		if value, err = r.ParseBinary(0); err != nil {
+			return
+		}
+		stmt.Values = append(stmt.Values, value)
+		if _, ok := r.MatchAndConsume(ast.Comma); !ok {
+			break
+		}
+	}
+	if _, err = r.Expect(ast.RightBrace, "',' or '}'"); err != nil {
+		return
+	}
+	r.optionalSemicolon()
+	return
+}
+
+// ParseAnnotatedStmt parses a constraint or an assert preceded by its annotations, `@owner("payments") constraint PayApi { ... }`.
+func (r *Parser) ParseAnnotatedStmt() (ast.Stmt, error) {
+	annotations, err := r.ParseAnnotations()
+	if err != nil {
+		return nil, err
+	}
+	if r.TokenType() == ast.Assert {
+		stmt, err := r.ParseAssertStmt()
+		stmt.Annotations = annotations
+		return stmt, err
+	}
+	_, abstract := r.MatchAndConsume(ast.Abstract)
+	stmt, err := r.ParseConstraintStmt(abstract)
+	stmt.Annotations = annotations
+	return stmt, err
+}
+
+// ParseAnnotations parses the annotations written before a constraint or an assert, `@since("2024-03")`,
+// the parentheses may be omitted when there are no arguments.
+func (r *Parser) ParseAnnotations() (annotations []ast.Annotation, err error) {
+	for r.TokenType() == ast.At {
+		annotation := ast.Annotation{At: r.This()}
 		r.Advance()
+		if r.TokenType() != ast.Ident {
+			return nil, ast.ExpectedErr(ast.UnexpectedToken, r.This(), "annotation name")
+		}
+		annotation.Name = r.This()
+		// the arguments are parsed as those of a call
+		if r.Peek().TokenType == ast.LeftParen {
+			var call ast.Expr
+			if call, err = r.ParseCallExpr(); err != nil {
+				return nil, err
+			}
+			annotation.Args = call.(ast.CallExpr).Args
+		} else {
+			r.Advance()
+		}
+		annotations = append(annotations, annotation)
+	}
+	return
+}
+
+func (r *Parser) ParseConstraintStmt(prefixAbstract bool) (stmt ast.ConstraintStmt, err error) {
+	stmt.IsAbstract = prefixAbstract
+	if _, err = r.expect(ast.InvalidConstraint, ast.Constraint, "'constraint'"); err != nil {
+		return
+	}
+	stmt.Comments = r.TakeComments()
+	stmt.Doc = ast.Doc(stmt.Comments)
+	if stmt.Id, err = r.expect(ast.InvalidConstraint, ast.Ident, "constraint name"); err != nil {
+		return
 	}
-	_, ok = r.MatchAndConsume(ast.LeftBrace)
-	if !ok {
-		panic("Expected left brace")
+	if _, ok := r.MatchAndConsume(ast.Extends); ok {
+		if stmt.ParentConstraint, err = r.expect(ast.InvalidConstraint, ast.Ident, "parent constraint name"); err != nil {
+			return
+		}
+		// a constraint which only inherits its parent may omit the body
+		if _, ok := r.MatchAndConsume(ast.Semicolon); ok {
+			return
+		}
+	}
+	if _, err = r.expect(ast.InvalidConstraint, ast.LeftBrace, "'{'"); err != nil {
+		return
 	}
 
 	// Stmts
-	for r.TokenType() == ast.Let {
-		assign, _ := r.ParseAssignStmt()
-		stmt.LetStmts = append(stmt.LetStmts, assign)
-	}
-	for r.TokenType() == ast.Assert {
-		assert, _ := r.ParseAssertStmt()
-		stmt.AssertStmts = append(stmt.AssertStmts, assert)
+	for r.TokenType() != ast.RightBrace {
+		switch r.TokenType() {
+		case ast.Let:
+			assign, err := r.ParseAssignStmt()
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.LetStmts = append(stmt.LetStmts, assign)
+		case ast.Assert, ast.At:
+			assert, err := r.ParseAssertStmt()
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.AssertStmts = append(stmt.AssertStmts, assert)
+		case ast.When:
+			when, err := r.ParseWhenStmt()
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.WhenStmts = append(stmt.WhenStmts, when)
+		case ast.Eof, ast.Constraint, ast.Abstract:
+			// the closing brace is missing, let the caller carry on with the next constraint
+			r.report(ast.ExpectedErr(ast.InvalidConstraint, r.This(), "'}'"))
+			return
+		default:
+			r.report(ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'let', 'assert' or 'when'"))
+			r.Advance()
+			r.Synchronize()
+		}
 	}
+	r.Advance()
+	stmt.EndComments = r.TakeComments()
+	r.optionalSemicolon()
+	return
+}
 
-	_, ok = r.MatchAndConsume(ast.RightBrace)
-	if !ok {
-		panic("Expected right brace")
+// ParseWhenStmt parses asserts guarded by a condition, `when type == "card" { assert ... }`.
+func (r *Parser) ParseWhenStmt() (stmt ast.WhenStmt, err error) {
+	if stmt.Keyword, err = r.Expect(ast.When, "'when'"); err != nil {
+		return
+	}
+	stmt.Comments = r.TakeComments()
+	if stmt.Guard, err = r.ParseExpr(); err != nil {
+		return
 	}
-	_, ok = r.MatchAndConsume(ast.Semicolon)
-	if !ok {
-		panic("Expected semicolon")
+	if _, err = r.Expect(ast.LeftBrace, "'{'"); err != nil {
+		return
+	}
+	for r.TokenType() != ast.RightBrace {
+		switch r.TokenType() {
+		case ast.Assert, ast.At:
+			assert, err := r.ParseAssertStmt()
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.AssertStmts = append(stmt.AssertStmts, assert)
+		case ast.Eof, ast.Constraint, ast.Abstract:
+			err = ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'}'")
+			return
+		default:
+			// lets are declared by the constraint, guards are combined with 'and'
+			r.report(ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'assert'"))
+			r.Advance()
+			r.Synchronize()
+		}
 	}
+	r.Advance()
+	stmt.EndComments = r.TakeComments()
+	r.optionalSemicolon()
 	return
 }
 
-func (r *Parser) ParseAssertStmt() (stmt ast.AssertStmt, ok bool) {
-	_, ok = r.MatchAndConsume(ast.Assert)
-	stmt.Id, ok = r.MatchAndConsume(ast.Ident)
-	if !ok {
-		panic("Expected identifier")
+func (r *Parser) ParseAssertStmt() (stmt ast.AssertStmt, err error) {
+	if stmt.Annotations, err = r.ParseAnnotations(); err != nil {
+		return
+	}
+	if _, err = r.Expect(ast.Assert, "'assert'"); err != nil {
+		return
+	}
+	stmt.Comments = r.TakeComments()
+	// the comments found later inside the assert are kept with it but do not document it
+	stmt.Doc = ast.Doc(stmt.Comments)
+	for r.TokenType() == ast.Required || r.TokenType() == ast.Optional || r.TokenType() == ast.Nullable {
+		stmt.Modifiers = append(stmt.Modifiers, r.This())
+		r.Advance()
 	}
-	_, ok = r.MatchAndConsume(ast.As)
-	if ok {
-		stmt.Alias, ok = r.MatchAndConsume(ast.Ident)
-		if !ok {
-			panic("Expected identifier")
+	if r.TokenType() == ast.LeftParen && len(stmt.Modifiers) == 0 {
+		err = r.ParseCrossFieldAssert(&stmt)
+		return
+	}
+	if stmt.Id, err = r.ParseFieldName(); err != nil {
+		return
+	}
+	for r.TokenType() == ast.Dot {
+		r.Advance()
+		stmt.Path = append(stmt.Path, stmt.Id)
+		if stmt.Id, err = r.ParseFieldName(); err != nil {
+			return
 		}
 	}
-	_, ok = r.MatchAndConsume(ast.Arrow)
-	if !ok {
-		panic("Expected arrow")
+	if _, ok := r.MatchAndConsume(ast.Colon); ok {
+		if stmt.Type, err = r.ParseTypeName("type name"); err != nil {
+			return
+		}
+		if _, ok := r.MatchAndConsume(ast.Format); ok {
+			if stmt.Format, err = r.ParseTypeName("format name"); err != nil {
+				return
+			}
+		}
 	}
-	stmt.Exprs = append(stmt.Exprs, r.ParseExpr())
-	for r.TokenType() == ast.And || r.TokenType() == ast.Or {
-		token := r.This()
+	if _, ok := r.MatchAndConsume(ast.Default); ok {
+		if stmt.Default, err = r.ParseExpr(); err != nil {
+			return
+		}
+		// parentheses after a default would read as the arguments of a call, `default first (p)`
+		if r.TokenType() == ast.LeftParen {
+			err = ast.NewDiagnostic(ast.UnexpectedToken, ast.TokenSpan(r.This()), "The alias of a field with a default is written after 'as'")
+			return
+		}
+	}
+	if _, ok := r.MatchAndConsume(ast.LeftParen); ok {
+		if stmt.Alias, err = r.Expect(ast.Ident, "alias"); err != nil {
+			return
+		}
+		if _, err = r.Expect(ast.RightParen, "')'"); err != nil {
+			return
+		}
+	} else if _, ok := r.MatchAndConsume(ast.As); ok {
+		if stmt.Alias, err = r.Expect(ast.Ident, "alias"); err != nil {
+			return
+		}
+	}
+	switch r.TokenType() {
+	case ast.Each, ast.All, ast.Exists:
+		stmt.Quantifier = r.This()
 		r.Advance()
-		stmt.Exprs = append(stmt.Exprs, token)
-		stmt.Exprs = append(stmt.Exprs, r.ParseExpr())
+		if _, err = r.Expect(ast.LeftParen, "'('"); err != nil {
+			return
+		}
+		if stmt.Element, err = r.Expect(ast.Ident, "element name"); err != nil {
+			return
+		}
+		if _, err = r.Expect(ast.RightParen, "')'"); err != nil {
+			return
+		}
+	}
+	// a declared field needs no body, `assert id: uuid;`
+	if r.TokenType() == ast.Semicolon && stmt.Declares() {
+		r.Advance()
+		stmt.Comments = append(stmt.Comments, r.TakeComments()...)
+		return
+	}
+	// the arrow may be omitted before a block of nested asserts
+	if r.TokenType() == ast.LeftBrace {
+		err = r.ParseAssertBlock(&stmt)
+		return
+	}
+	if _, err = r.Expect(ast.Arrow, "'=>'"); err != nil {
+		return
 	}
-	_, ok = r.MatchAndConsume(ast.Semicolon)
-	if !ok {
-		panic("Expected semicolon")
+	if r.TokenType() == ast.LeftBrace {
+		err = r.ParseAssertBlock(&stmt)
+		return
 	}
+	expr, err := r.ParseExpr()
+	if err != nil {
+		return
+	}
+	violation, err := r.ParseViolation()
+	if err != nil {
+		return
+	}
+	stmt.Exprs = append(stmt.Exprs, expr)
+	stmt.Violations = append(stmt.Violations, violation)
+	_, err = r.Expect(ast.Semicolon, "';'")
+	// comments inside a single expression are kept in front of the assert
+	stmt.Comments = append(stmt.Comments, r.TakeComments()...)
 	return
-
 }
 
-func (r *Parser) ParseAssignStmt() (stmt ast.AssignStmt, ok bool) {
-	_, ok = r.MatchAndConsume(ast.Let)
-	stmt.Id, ok = r.MatchAndConsume(ast.Ident)
-	if !ok {
-		panic("Expected identifier")
+// ParseCrossFieldAssert parses an assert relating several fields, `assert (start, end) => start < end;`.
+// Its body is a single expression or a block of expressions, fields cannot be nested in it.
+func (r *Parser) ParseCrossFieldAssert(stmt *ast.AssertStmt) (err error) {
+	r.Advance()
+	for {
+		var field ast.Token
+		if field, err = r.ParseFieldName(); err != nil {
+			return
+		}
+		stmt.Fields = append(stmt.Fields, field)
+		if _, ok := r.MatchAndConsume(ast.Comma); !ok {
+			break
+		}
+	}
+	if _, err = r.Expect(ast.RightParen, "',' or ')'"); err != nil {
+		return
 	}
-	_, ok = r.MatchAndConsume(ast.Assign)
-	if !ok {
-		panic("Expected assignment")
+	if _, err = r.Expect(ast.Arrow, "'=>'"); err != nil {
+		return
 	}
-	stmt.Expr = r.ParseExpr()
-	_, ok = r.MatchAndConsume(ast.Semicolon)
-	if !ok {
-		panic("Expected semicolon")
+	if r.TokenType() == ast.LeftBrace {
+		err = r.ParseAssertBlock(stmt)
+		return
 	}
+	expr, err := r.ParseExpr()
+	if err != nil {
+		return
+	}
+	violation, err := r.ParseViolation()
+	if err != nil {
+		return
+	}
+	stmt.Exprs = append(stmt.Exprs, expr)
+	stmt.Violations = append(stmt.Violations, violation)
+	_, err = r.Expect(ast.Semicolon, "';'")
+	stmt.Comments = append(stmt.Comments, r.TakeComments()...)
 	return
 }
 
-func (r *Parser) ParseExpr() ast.Expr {
-	return r.ParseComparison()
+// ParseTypeName parses the name of a type or of a format, the words of a name such as date-time are joined by hyphens.
+func (r *Parser) ParseTypeName(expected string) (ast.Token, error) {
+	name, err := r.Expect(ast.Ident, expected)
+	for err == nil && r.TokenType() == ast.Minus && r.Peek().TokenType == ast.Ident {
+		r.Advance()
+		name.Literal += "-" + r.This().Literal
+		r.Advance()
+	}
+	return name, err
 }
 
-func (r *Parser) ParseComparison() ast.Expr {
-	left := r.ParsePlusMinus()
-	for r.TokenType() == ast.GreaterThan || r.TokenType() == ast.GreaterThanOrEqual ||
-		r.TokenType() == ast.LessThan || r.TokenType() == ast.LessThanOrEqual ||
-		r.TokenType() == ast.Equal || r.TokenType() == ast.NotEqual {
-		token := r.This()
-		r.Advance()
-		right := r.ParsePlusMinus()
-		left = ast.BinaryExpr{Left: left, Op: token, Right: right}
+// ParseFieldName parses the name of an asserted field, names which are not identifiers such as
+// "content-type" or `not` are quoted and can only be referred to through an alias.
+func (r *Parser) ParseFieldName() (ast.Token, error) {
+	token := r.This()
+	switch {
+	case token.TokenType == ast.Ident:
+	case token.TokenType == ast.Value && token.LiteralType == ast.String:
+		token.TokenType, token.LiteralType = ast.Ident, ast.Any
+	default:
+		return token, ast.ExpectedErr(ast.UnexpectedToken, token, "field name")
 	}
-	return left
+	r.Advance()
+	return token, nil
+}
 
+// ParseAssertBlock parses the body of an assert, a list of expressions terminated by semicolons
+// which must all hold, and nested asserts on the fields of an object.
+func (r *Parser) ParseAssertBlock(stmt *ast.AssertStmt) (err error) {
+	if _, err = r.Expect(ast.LeftBrace, "'{'"); err != nil {
+		return
+	}
+	for r.TokenType() != ast.RightBrace {
+		switch r.TokenType() {
+		case ast.Assert, ast.At:
+			// the fields of a cross-field assert belong to the enclosing object and have no fields of their own,
+			// the fields of the elements of a list are referred to through the element name
+			if len(stmt.Fields) > 0 || stmt.Quantifier.Literal != "" {
+				r.report(ast.ExpectedErr(ast.UnexpectedToken, r.This(), "an expression"))
+				r.Advance()
+				r.Synchronize()
+				continue
+			}
+			assert, err := r.ParseAssertStmt()
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.Stmts = append(stmt.Stmts, assert)
+		case ast.Eof, ast.Constraint, ast.Abstract:
+			err = ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'}'")
+			return
+		case ast.Let, ast.Enum, ast.When:
+			// only asserts and expressions are allowed in the body of an assert, the other statements
+			// are skipped since Synchronize would stop right at them
+			r.report(ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'assert' or an expression"))
+			r.Advance()
+			r.Synchronize()
+		default:
+			expr, err := r.ParseExpr()
+			var violation ast.Violation
+			if err == nil {
+				violation, err = r.ParseViolation()
+			}
+			if err == nil {
+				_, err = r.Expect(ast.Semicolon, "';'")
+			}
+			if err != nil {
+				r.report(err)
+				r.Synchronize()
+				continue
+			}
+			stmt.Exprs = append(stmt.Exprs, expr)
+			stmt.Violations = append(stmt.Violations, violation)
+			stmt.ExprComments = append(stmt.ExprComments, r.TakeComments())
+		}
+	}
+	r.Advance()
+	stmt.EndComments = r.TakeComments()
+	r.optionalSemicolon()
+	return
 }
 
-func (r *Parser) ParsePlusMinus() ast.Expr {
-	left := r.ParseMultiplyDivide()
-	for r.TokenType() == ast.Plus || r.TokenType() == ast.Minus {
-		token := r.This()
+// ParseViolation parses the message and the code reported when an expression does not hold, `else "message" code "CODE"`,
+// either may be omitted. `code` is not a keyword so that it remains a valid field name.
+func (r *Parser) ParseViolation() (violation ast.Violation, err error) {
+	if _, ok := r.MatchAndConsume(ast.Else); ok {
+		if violation.Message, err = r.ParseString("message"); err != nil {
+			return
+		}
+	}
+	if r.TokenType() == ast.Ident && r.This().Literal == "code" {
 		r.Advance()
-		right := r.ParseMultiplyDivide()
-		left = ast.BinaryExpr{Left: left, Op: token, Right: right}
+		violation.Code, err = r.ParseString("code")
 	}
-	return left
+	return
 }
 
-func (r *Parser) ParseMultiplyDivide() ast.Expr {
-	left := r.ParseLogical()
-	for r.TokenType() == ast.Multiply || r.TokenType() == ast.Divide {
-		token := r.This()
-		r.Advance()
-		right := r.ParseLogical()
-		left = ast.BinaryExpr{Left: left, Op: token, Right: right}
+// ParseString parses a string value.
+func (r *Parser) ParseString(expected string) (ast.Token, error) {
+	token := r.This()
+	if token.TokenType != ast.Value || token.LiteralType != ast.String {
+		return token, ast.ExpectedErr(ast.UnexpectedToken, token, expected)
 	}
-	return left
+	r.Advance()
+	return token, nil
 }
 
-func (r *Parser) ParseLogical() ast.Expr {
-	left := r.ParseParentheses()
-	for r.TokenType() == ast.And || r.TokenType() == ast.Or {
-		token := r.This()
-		r.Advance()
-		right := r.ParseParentheses()
-		left = ast.BinaryExpr{Left: left, Op: token, Right: right}
+func (r *Parser) ParseAssignStmt() (stmt ast.AssignStmt, err error) {
+	if _, err = r.Expect(ast.Let, "'let'"); err != nil {
+		return
+	}
+	stmt.Comments = r.TakeComments()
+	if stmt.Id, err = r.Expect(ast.Ident, "identifier"); err != nil {
+		return
+	}
+	if _, err = r.Expect(ast.Assign, "'='"); err != nil {
+		return
 	}
-	return left
+	if stmt.Expr, err = r.ParseExpr(); err != nil {
+		return
+	}
+	_, err = r.Expect(ast.Semicolon, "';'")
+	return
 }
 
-func (r *Parser) ParseParentheses() ast.Expr {
-	if r.TokenType() == ast.LeftParen {
-		r.Advance()
-		expr := r.ParseComparison()
-		r.Advance()
-		return expr
+// Associativity tells how operators of the same precedence group, `a - b - c` is (a - b) - c
+// because minus is left associative. Comparisons are not associative and cannot be chained.
+type Associativity int
+
+const (
+	LeftAssociative Associativity = iota
+	RightAssociative
+	NonAssociative
+)
+
+// Operator is the binding power of a binary operator, higher precedences bind tighter.
+type Operator struct {
+	Precedence    int
+	Associativity Associativity
+}
+
+const (
+	PrecedenceOr = iota + 1
+	PrecedenceAnd
+	PrecedenceNot
+	PrecedenceComparison
+	PrecedenceAdditive
+	PrecedenceMultiplicative
+	PrecedenceUnary
+)
+
+// Operators drives the parsing of binary expressions, `is` and `unique` bind like a comparison.
+var Operators = map[ast.TokenType]Operator{
+	ast.Or:                 {PrecedenceOr, LeftAssociative},
+	ast.And:                {PrecedenceAnd, LeftAssociative},
+	ast.Equal:              {PrecedenceComparison, NonAssociative},
+	ast.NotEqual:           {PrecedenceComparison, NonAssociative},
+	ast.GreaterThan:        {PrecedenceComparison, NonAssociative},
+	ast.GreaterThanOrEqual: {PrecedenceComparison, NonAssociative},
+	ast.LessThan:           {PrecedenceComparison, NonAssociative},
+	ast.LessThanOrEqual:    {PrecedenceComparison, NonAssociative},
+	ast.Is:                 {PrecedenceComparison, NonAssociative},
+	ast.Contains:           {PrecedenceComparison, NonAssociative},
+	ast.StartsWith:         {PrecedenceComparison, NonAssociative},
+	ast.EndsWith:           {PrecedenceComparison, NonAssociative},
+	ast.Matches:            {PrecedenceComparison, NonAssociative},
+	ast.In:                 {PrecedenceComparison, NonAssociative},
+	ast.NotIn:              {PrecedenceComparison, NonAssociative},
+	ast.Unique:             {PrecedenceComparison, NonAssociative},
+	ast.Plus:               {PrecedenceAdditive, LeftAssociative},
+	ast.Minus:              {PrecedenceAdditive, LeftAssociative},
+	ast.Multiply:           {PrecedenceMultiplicative, LeftAssociative},
+	ast.Divide:             {PrecedenceMultiplicative, LeftAssociative},
+	ast.Modulo:             {PrecedenceMultiplicative, LeftAssociative},
+}
+
+// PrefixPrecedence is the binding power of a prefix operator over its operand, the keyword `not`
+// negates a whole comparison while `!` and the signs only apply to the operand next to them.
+func PrefixPrecedence(op ast.Token) (int, bool) {
+	switch op.TokenType {
+	case ast.Not:
+		if op.Literal == "not" {
+			return PrecedenceNot, true
+		}
+		return PrecedenceUnary, true
+	case ast.Plus, ast.Minus:
+		return PrecedenceUnary, true
 	}
-	return r.ParseUnary()
+	return 0, false
 }
 
-func (r *Parser) ParseUnary() ast.Expr {
-	if r.TokenType() == ast.Not || r.TokenType() == ast.Minus {
-		token := r.This()
-		r.Advance()
-		expr := r.ParseValue()
-		return ast.UnaryExpr{Op: token, Expr: expr}
+// ParseExpr parses a whole expression, a closing parenthesis left over is unbalanced.
+func (r *Parser) ParseExpr() (ast.Expr, error) {
+	expr, err := r.ParseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.TokenType() == ast.RightParen {
+		return nil, ast.NewDiagnostic(ast.UnexpectedToken, ast.TokenSpan(r.This()), "Unbalanced ')', no '(' to close")
+	}
+	return expr, nil
+}
+
+// ParseBinary parses the operators which bind tighter than precedence.
+func (r *Parser) ParseBinary(precedence int) (ast.Expr, error) {
+	left, err := r.ParsePrefix()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, op, ok := r.Infix()
+		if !ok || op.Precedence <= precedence {
+			return left, nil
+		}
+		switch token.TokenType {
+		case ast.Is:
+			left, err = r.ParseIsExpr(left)
+		case ast.Unique:
+			left, err = r.ParseUniqueExpr(left)
+		default:
+			r.Advance()
+			if token.TokenType == ast.NotIn {
+				r.Advance()
+			}
+			next := op.Precedence
+			if op.Associativity == RightAssociative {
+				next--
+			}
+			var right ast.Expr
+			if right, err = r.ParseBinary(next); err == nil {
+				left = ast.BinaryExpr{Left: left, Op: token, Right: right}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if following, next, ok := r.Infix(); ok && op.Associativity == NonAssociative && next.Precedence == op.Precedence {
+			return nil, ast.NewDiagnostic(ast.UnexpectedToken, ast.TokenSpan(following), "Comparisons cannot be chained, '%s' follows '%s'", following.Literal, token.Literal).
+				WithNote("combine the comparisons with 'and' or wrap one of them in parentheses")
+		}
 	}
-	return r.ParseValue()
 }
 
-func (r *Parser) ParseValue() ast.Expr {
+// Infix returns the binary operator at the current token, `not in` is read as a single operator.
+func (r *Parser) Infix() (ast.Token, Operator, bool) {
 	token := r.This()
+	if token.TokenType == ast.Not && r.Peek().TokenType == ast.In {
+		token.TokenType, token.Literal = ast.NotIn, "not in"
+	}
+	op, ok := Operators[token.TokenType]
+	return token, op, ok
+}
+
+// ParseCallExpr parses a function call `callee(arg, ...)`.
+func (r *Parser) ParseCallExpr() (ast.Expr, error) {
+	call := ast.CallExpr{Callee: r.This()}
+	r.Advance()
+	open, err := r.Expect(ast.LeftParen, "'('")
+	if err != nil {
+		return nil, err
+	}
+	for r.TokenType() != ast.RightParen {
+		if len(call.Args) > 0 {
+			if r.TokenType() != ast.Comma {
+				return nil, ast.ExpectedErr(ast.UnexpectedToken, r.This(), "',' or ')'").
+					WithNote(fmt.Sprintf("the '(' at %d:%d is not closed", open.DebugInfo.Line, open.DebugInfo.Column))
+			}
+			r.Advance()
+		}
+		arg, err := r.ParseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+	}
+	call.RightParen = r.This()
+	r.Advance()
+	return call, nil
+}
+
+// ParseListExpr parses a list literal `[value, ...]`, a trailing comma is allowed.
+func (r *Parser) ParseListExpr() (ast.Expr, error) {
+	list := ast.ListExpr{LeftBracket: r.This()}
 	r.Advance()
-	return token
+	for r.TokenType() != ast.RightBracket {
+		element, err := r.ParseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		list.Elements = append(list.Elements, element)
+		if _, ok := r.MatchAndConsume(ast.Comma); !ok {
+			break
+		}
+	}
+	var err error
+	if list.RightBracket, err = r.Expect(ast.RightBracket, "',' or ']'"); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ParseIsExpr parses the predicate of `expr is [not] predicate`.
+func (r *Parser) ParseIsExpr(expr ast.Expr) (ast.Expr, error) {
+	op, err := r.Expect(ast.Is, "'is'")
+	if err != nil {
+		return nil, err
+	}
+	_, not := r.MatchAndConsume(ast.Not)
+	switch r.TokenType() {
+	case ast.Empty, ast.Null, ast.Ident:
+		predicate := r.This()
+		r.Advance()
+		return ast.IsExpr{Expr: expr, Op: op, Not: not, Predicate: predicate}, nil
+	}
+	return nil, ast.ExpectedErr(ast.UnexpectedToken, r.This(), "'empty', 'null' or a type name")
+}
+
+// ParseUniqueExpr parses `expr unique [by field]`.
+func (r *Parser) ParseUniqueExpr(expr ast.Expr) (ast.Expr, error) {
+	op, err := r.Expect(ast.Unique, "'unique'")
+	if err != nil {
+		return nil, err
+	}
+	unique := ast.UniqueExpr{Expr: expr, Op: op}
+	if _, ok := r.MatchAndConsume(ast.By); ok {
+		if unique.By, err = r.ParseFieldName(); err != nil {
+			return nil, err
+		}
+	}
+	return unique, nil
+}
+
+// ParsePrefix parses a value, a parenthesized expression or a prefix operator and its operand.
+func (r *Parser) ParsePrefix() (ast.Expr, error) {
+	token := r.This()
+	if precedence, ok := PrefixPrecedence(token); ok {
+		r.Advance()
+		expr, err := r.ParseBinary(precedence - 1)
+		if err != nil {
+			return nil, err
+		}
+		return ast.UnaryExpr{Op: token, Expr: expr}, nil
+	}
+	switch token.TokenType {
+	case ast.LeftParen:
+		r.Advance()
+		expr, err := r.ParseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if r.TokenType() != ast.RightParen {
+			return nil, ast.ExpectedErr(ast.UnexpectedToken, r.This(), "')'").
+				WithNote(fmt.Sprintf("the '(' at %d:%d is not closed", token.DebugInfo.Line, token.DebugInfo.Column))
+		}
+		r.Advance()
+		return expr, nil
+	case ast.Ident:
+		if r.Peek().TokenType == ast.LeftParen {
+			return r.ParseCallExpr()
+		}
+		r.Advance()
+		var expr ast.Expr = token
+		for r.TokenType() == ast.Dot {
+			r.Advance()
+			name, err := r.ParseFieldName()
+			if err != nil {
+				return nil, err
+			}
+			expr = ast.MemberExpr{Expr: expr, Name: name}
+		}
+		return expr, nil
+	case ast.LeftBracket:
+		return r.ParseListExpr()
+	case ast.Value, ast.Null:
+		r.Advance()
+		return token, nil
+	}
+	return nil, ast.ExpectedErr(ast.UnexpectedToken, token, "an expression")
+}
+
+// ensureEof terminates token streams which were not produced by the lexer.
+func ensureEof(tokens []ast.Token) []ast.Token {
+	if len(tokens) == 0 || tokens[len(tokens)-1].TokenType != ast.Eof {
+		return append(tokens, ast.NewToken(ast.Eof, "Eof", ast.Any, 0, 0))
+	}
+	return tokens
 }
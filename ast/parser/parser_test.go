@@ -1,25 +1,444 @@
 package parser
 
 import (
+	"customs/ast"
 	"customs/ast/scanner"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 )
 
-func TestParser_Parse(t *testing.T) {
-	input := `let x = 1 + 2 * (3 - 4);`
+// parse returns the statements of the input along with the syntax errors reported on the way.
+func parse(t *testing.T, input string) ([]ast.Stmt, ast.Diagnostics) {
+	t.Helper()
 	lexer := scanner.NewLexer(input)
-	err := lexer.Scan()
-	if err != nil {
-		t.Errorf("Error = %v\n", err)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
 	}
 	parser := NewParser(lexer.Tokens)
 	stmts, _ := parser.Parse()
+	return stmts, parser.Diagnostics
+}
+
+// expectDiagnostics fails the test unless exactly the expected errors were reported, in order.
+func expectDiagnostics(t *testing.T, diagnostics ast.Diagnostics, expected []string) {
+	t.Helper()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Parse() reported %v, want %v", diagnostics, expected)
+	}
+	for i, e := range diagnostics {
+		if e.Error() != expected[i] {
+			t.Errorf("Errors[%d] = %q, want %q", i, e, expected[i])
+		}
+	}
+}
+
+func TestParser_Parse(t *testing.T) {
+	input := `let x = 1 + 2 * (3 - 4);`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, nil)
 	for _, stmt := range stmts {
 		fmt.Printf("%s\n", stmt)
 	}
-	//fmt.Printf("%s\n", PrefixTraversal(expr))
-	if err != nil {
-		t.Errorf("Error = %v\n", err)
+}
+
+func TestParser_ParseRecovery(t *testing.T) {
+	input := `let x = ;
+	constraint RegisterApi {
+		let y = (1 + 2;
+		assert token => token > y;
+		assert => 1;
+	}
+	let z = 1`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[1:9] E003 Expected an expression, found ';'",
+		"[3:17] E003 Expected ')', found ';'",
+		"[5:10] E003 Expected field name, found '=>'",
+		"[7:11] E003 Expected ';', found end of file",
+	})
+	// the well formed statements are still parsed
+	if len(stmts) != 1 {
+		t.Fatalf("Parse() returned %d statements, want 1", len(stmts))
+	}
+}
+
+func TestParser_ParseAssertBlock(t *testing.T) {
+	input := `constraint RegisterApi {
+		assert token (t) => {
+			t > 1;
+			t < 100;
+			assert id => id > 0;
+		};
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, nil)
+	assert := stmts[0].(ast.ConstraintStmt).AssertStmts[0]
+	if assert.Alias.Literal != "t" {
+		t.Errorf("Alias = %v, want t", assert.Alias)
+	}
+	var exprs []string
+	for _, expr := range assert.Exprs {
+		exprs = append(exprs, ast.PrefixTraversal(expr))
+	}
+	if expected := []string{"(> t 1)", "(< t 100)"}; !slices.Equal(exprs, expected) {
+		t.Errorf("Exprs = %v, want %v", exprs, expected)
+	}
+	if len(assert.Stmts) != 1 || assert.Stmts[0].Id.Literal != "id" {
+		t.Errorf("Stmts = %v, want the assert on id", assert.Stmts)
+	}
+}
+
+func TestParser_ParsePrecedence(t *testing.T) {
+	tests := map[string]string{
+		`a > 1 and b < 2`:               `(and (> a 1) (< b 2))`,
+		`a or b and c or d`:             `(or (or a (and b c)) d)`,
+		`1 + 2 * 3 % 4 - 5`:             `(- (+ 1 (% (* 2 3) 4)) 5)`,
+		`not a > 1 and b`:               `(and not((> a 1)) b)`,
+		`!a or -(b + c) * -d == 0`:      `(or !(a) (== (* -((+ b c)) -(d)) 0))`,
+		`a - -b - c`:                    `(- (- a -(b)) c)`,
+		`(a or b) and x is not empty`:   `(and (or a b) (is not x empty))`,
+		`len(name) <= 64 and now() > 0`: `(and (<= len(name) 64) (> now() 0))`,
+		`-min(a, b * 2, abs(c)) + 1`:    `(+ -(min(a (* b 2) abs(c))) 1)`,
+		`a not in [1, 2,] or b in c`:    `(or (not in a [1 2]) (in b c))`,
+		`i.qty * 2 > i."unit-price"`:    `(> (* i.qty 2) i.unit-price)`,
+		`a unique by id and b unique`:   `(and (unique a id) (unique b))`,
+	}
+	for input, expected := range tests {
+		lexer := scanner.NewLexer(input + ";")
+		if err := lexer.Scan(); err != nil {
+			t.Fatalf("Error = %v\n", err)
+		}
+		parser := NewParser(lexer.Tokens)
+		expr, err := parser.ParseExpr()
+		if err != nil {
+			t.Errorf("ParseExpr(%q) error = %v", input, err)
+			continue
+		}
+		if got := ast.PrefixTraversal(expr); got != expected {
+			t.Errorf("ParseExpr(%q) = %s, want %s", input, got, expected)
+		}
+	}
+}
+
+func TestParser_ParseExprErrors(t *testing.T) {
+	tests := map[string]string{
+		`(a + 1;`:          `[1:7] E003 Expected ')', found ';'`,
+		`a + 1);`:          `[1:6] E003 Unbalanced ')', no '(' to close`,
+		`1 < a < 10;`:      `[1:7] E003 Comparisons cannot be chained, '<' follows '<'`,
+		`a == b is empty;`: `[1:8] E003 Comparisons cannot be chained, 'is' follows '=='`,
+		`a * ;`:            `[1:5] E003 Expected an expression, found ';'`,
+		`min(a b);`:        `[1:7] E003 Expected ',' or ')', found 'b'`,
+		`min(a, );`:        `[1:8] E003 Expected an expression, found ')'`,
+		`a in [1 2];`:      `[1:9] E003 Expected ',' or ']', found '2'`,
+		`a in b in c;`:     `[1:8] E003 Comparisons cannot be chained, 'in' follows 'in'`,
+		`a unique == b;`:   `[1:10] E003 Comparisons cannot be chained, '==' follows 'unique'`,
+		`a.1;`:             `[1:3] E003 Expected field name, found '1'`,
+	}
+	for input, expected := range tests {
+		lexer := scanner.NewLexer(input)
+		if err := lexer.Scan(); err != nil {
+			t.Fatalf("Error = %v\n", err)
+		}
+		parser := NewParser(lexer.Tokens)
+		_, err := parser.ParseExpr()
+		if err == nil || err.Error() != expected {
+			t.Errorf("ParseExpr(%q) error = %v, want %s", input, err, expected)
+		}
+	}
+}
+
+func TestParser_ParseEnumStmt(t *testing.T) {
+	input := `enum Currency { "EUR", "USD", }
+	enum Level { 1 };
+	constraint RegisterApi {
+		assert currency => currency in Currency;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, nil)
+	if len(stmts) != 3 {
+		t.Fatalf("Parse() returned %d statements, want 3", len(stmts))
+	}
+	enum := stmts[0].(ast.EnumStmt)
+	var values []string
+	for _, value := range enum.Values {
+		values = append(values, ast.PrefixTraversal(value))
+	}
+	if expected := []string{"EUR", "USD"}; enum.Id.Literal != "Currency" || !slices.Equal(values, expected) {
+		t.Errorf("EnumStmt = %s %v, want Currency %v", enum.Id.Literal, values, expected)
+	}
+}
+
+func TestParser_ParseEnumInAssertBlock(t *testing.T) {
+	// Synchronize stops at 'enum', the assert block must skip it to terminate
+	input := `constraint C { assert a => { enum E { 1 } } }`
+	_, diagnostics := parse(t, input)
+	expected := "[1:30] E003 Expected 'assert' or an expression, found 'enum'"
+	if len(diagnostics) == 0 || diagnostics[0].Error() != expected {
+		t.Errorf("Parse() reported %v, want %q first", diagnostics, expected)
+	}
+}
+
+func TestParser_ParseWhenInAssertBlock(t *testing.T) {
+	// Synchronize stops at 'when', the assert block must skip it to terminate
+	input := `constraint C { assert a => { when a > 1 { assert required b; } } }`
+	_, diagnostics := parse(t, input)
+	expected := "[1:30] E003 Expected 'assert' or an expression, found 'when'"
+	if len(diagnostics) == 0 || diagnostics[0].Error() != expected {
+		t.Errorf("Parse() reported %v, want %q first", diagnostics, expected)
+	}
+}
+
+func TestParser_ParseCrossFieldAssert(t *testing.T) {
+	input := `constraint RegisterApi {
+		assert (start, "end") => start < end;
+		assert (from, to) => {
+			from < to;
+			assert to => to > 0;
+		}
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{"[5:4] E003 Expected an expression, found 'assert'"})
+	asserts := stmts[0].(ast.ConstraintStmt).AssertStmts
+	var fields []string
+	for _, field := range asserts[0].Fields {
+		fields = append(fields, field.Literal)
+	}
+	if expected := []string{"start", "end"}; !slices.Equal(fields, expected) {
+		t.Errorf("Fields = %v, want %v", fields, expected)
+	}
+	if len(asserts) != 2 || len(asserts[1].Exprs) != 1 || len(asserts[1].Stmts) != 0 {
+		t.Errorf("AssertStmts = %v, want the block without its nested assert", asserts)
+	}
+}
+
+func TestParser_ParseWhenStmt(t *testing.T) {
+	input := `constraint RegisterApi {
+		when kind == "card" and amount > 0 {
+			let x = 1;
+			assert card_number => card_number is not empty;
+		};
+		assert kind => kind is not empty;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{"[3:4] E003 Expected 'assert', found 'let'"})
+	constraint := stmts[0].(ast.ConstraintStmt)
+	if len(constraint.WhenStmts) != 1 || len(constraint.AssertStmts) != 1 {
+		t.Fatalf("ConstraintStmt = %v, want a when and an assert", constraint)
+	}
+	when := constraint.WhenStmts[0]
+	if got := ast.PrefixTraversal(when.Guard); got != `(and (== kind card) (> amount 0))` {
+		t.Errorf("Guard = %s", got)
+	}
+	if len(when.AssertStmts) != 1 || when.AssertStmts[0].Id.Literal != "card_number" {
+		t.Errorf("AssertStmts = %v, want the assert on card_number", when.AssertStmts)
+	}
+}
+
+func TestParser_ParseQuantifiers(t *testing.T) {
+	input := `constraint RegisterApi {
+		assert items each (i) => { i.qty > 0; };
+		assert addresses (list) any (a) => a.primary;
+		assert tags all (t) => {
+			assert name => name is not empty;
+		}
+		assert ids each i => i > 0;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[5:4] E003 Expected an expression, found 'assert'",
+		"[7:19] E003 Expected '(', found 'i'",
+	})
+	asserts := stmts[0].(ast.ConstraintStmt).AssertStmts
+	if len(asserts) != 3 {
+		t.Fatalf("AssertStmts = %v, want 3 asserts", asserts)
+	}
+	var quantifiers []string
+	for _, assert := range asserts {
+		quantifiers = append(quantifiers, assert.Quantifier.Literal+" "+assert.Element.Literal)
+	}
+	if expected := []string{"each i", "any a", "all t"}; !slices.Equal(quantifiers, expected) {
+		t.Errorf("Quantifiers = %v, want %v", quantifiers, expected)
+	}
+	if asserts[1].Alias.Literal != "list" {
+		t.Errorf("Alias = %v, want list", asserts[1].Alias)
+	}
+}
+
+func TestParser_ParseDeclarations(t *testing.T) {
+	input := `constraint RegisterApi {
+		assert user.email: string format email as e => e != "";
+		assert created_at: date-time;
+		assert name;
+		assert age: ;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[4:14] E003 Expected '=>', found ';'",
+		"[5:15] E003 Expected type name, found ';'",
+	})
+	asserts := stmts[0].(ast.ConstraintStmt).AssertStmts
+	var declarations []string
+	for _, assert := range asserts {
+		declarations = append(declarations, assert.Id.Literal+": "+assert.Type.Literal+" "+assert.Format.Literal)
+	}
+	if expected := []string{"email: string email", "created_at: date-time "}; !slices.Equal(declarations, expected) {
+		t.Errorf("Declarations = %v, want %v", declarations, expected)
+	}
+	if asserts[0].Alias.Literal != "e" || len(asserts[1].Exprs) != 0 {
+		t.Errorf("AssertStmts = %v", asserts)
+	}
+}
+
+func TestParser_ParseModifiers(t *testing.T) {
+	input := `constraint ListApi {
+		assert required id: uuid;
+		assert optional nullable page: integer default 1 + 1 => page > 0;
+		assert size default -1;
+		assert cursor default first as c => c != "";
+		assert limit default 10 (l) => l > 0;
+		assert required (start, end) => start < end;
+		assert optional;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[6:27] E003 The alias of a field with a default is written after 'as'",
+		"[7:19] E003 Expected field name, found '('",
+		"[8:18] E003 Expected field name, found ';'",
+	})
+	asserts := stmts[0].(ast.ConstraintStmt).AssertStmts
+	if len(asserts) != 4 {
+		t.Fatalf("AssertStmts = %v, want 4 asserts", asserts)
+	}
+	var modifiers []string
+	for _, assert := range asserts {
+		var names []string
+		for _, modifier := range assert.Modifiers {
+			names = append(names, modifier.Literal)
+		}
+		modifiers = append(modifiers, assert.Id.Literal+" "+strings.Join(names, " "))
+	}
+	if expected := []string{"id required", "page optional nullable", "size ", "cursor "}; !slices.Equal(modifiers, expected) {
+		t.Errorf("Modifiers = %v, want %v", modifiers, expected)
+	}
+	if got := ast.PrefixTraversal(asserts[1].Default); got != "(+ 1 1)" {
+		t.Errorf("Default = %s, want (+ 1 1)", got)
+	}
+	if got := ast.PrefixTraversal(asserts[2].Default); got != "-(1)" || len(asserts[1].Exprs) != 1 {
+		t.Errorf("AssertStmts = %v", asserts)
+	}
+	if got := ast.PrefixTraversal(asserts[3].Default); got != "first" || asserts[3].Alias.Literal != "c" {
+		t.Errorf("Default = %s, Alias = %s, want first and c", got, asserts[3].Alias.Literal)
+	}
+}
+
+func TestParser_ParseViolations(t *testing.T) {
+	input := `constraint TokenApi {
+		assert t => t > threshold else "token must exceed {threshold}" code "TOKEN_TOO_SMALL";
+		assert code => {
+			code != "" code "CODE_EMPTY";
+			len(code) < 10;
+			code != "x" else 1;
+		}
+		assert (start, end) => start < end else "start must precede end";
+		assert u => u != "" else "missing" code;
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[6:21] E003 Expected message, found '1'",
+		"[9:42] E003 Expected code, found ';'",
+	})
+	asserts := stmts[0].(ast.ConstraintStmt).AssertStmts
+	if len(asserts) != 3 {
+		t.Fatalf("AssertStmts = %v, want 3 asserts", asserts)
+	}
+	var violations []string
+	for _, assert := range asserts {
+		for i := range assert.Exprs {
+			violation := assert.Violation(i)
+			violations = append(violations, violation.Message.Literal+"|"+violation.Code.Literal)
+		}
+	}
+	expectedViolations := []string{"token must exceed {threshold}|TOKEN_TOO_SMALL", "|CODE_EMPTY", "|", "start must precede end|"}
+	if !slices.Equal(violations, expectedViolations) {
+		t.Errorf("Violations = %v, want %v", violations, expectedViolations)
+	}
+}
+
+func TestParser_ParseAnnotations(t *testing.T) {
+	input := `// the payments
+	@deprecated("use v2") @since("2024-03")
+	abstract constraint PayApi {
+		@severity(warning)
+		@example(40 + 2, "x")
+		assert amount => amount > 0;
+		@deprecated
+		let fee = 1;
+		when amount > 0 {
+			@deprecated() assert currency => currency != "";
+		}
+	}
+	@owner("payments")
+	assert x => x > 0;
+	@ 1
+	constraint RefundApi { assert id => id > 0; }`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, []string{
+		"[8:3] E003 Expected 'assert', found 'let'",
+		"[15:4] E003 Expected annotation name, found '1'",
+	})
+	// the statement after a misplaced annotation is still parsed
+	if len(stmts) != 3 {
+		t.Fatalf("Parse() = %v, want 3 statements", stmts)
+	}
+	if assert, ok := stmts[1].(ast.AssertStmt); !ok || fmt.Sprint(assert.Annotations) != "[@owner(payments)]" {
+		t.Errorf("Stmts[1] = %v, want the annotated assert", stmts[1])
+	}
+	constraint := stmts[0].(ast.ConstraintStmt)
+	if !constraint.IsAbstract || fmt.Sprint(constraint.Annotations) != `[@deprecated(use v2) @since(2024-03)]` {
+		t.Errorf("Annotations = %v", constraint.Annotations)
+	}
+	if len(constraint.Comments) != 1 {
+		t.Errorf("Comments = %v, want the comment before the annotations", constraint.Comments)
+	}
+	if got := fmt.Sprint(constraint.AssertStmts[0].Annotations); got != "[@severity(warning) @example((+ 40 2) x)]" {
+		t.Errorf("Annotations = %s", got)
+	}
+	if got := fmt.Sprint(constraint.WhenStmts[0].AssertStmts[0].Annotations); got != "[@deprecated()]" {
+		t.Errorf("Annotations = %s", got)
+	}
+}
+
+func TestParser_ParseDocComments(t *testing.T) {
+	input := `/// Creates a payment.
+	// not a doc comment
+	//// a separator
+	///
+	///   Charged at once.
+	abstract constraint PayApi {
+		/* block */ /// Amount in cents.
+		@example(42)
+		/// Must be positive.
+		assert amount => amount > /// not the doc of amount
+			0;
+		assert currency => currency != "";
+	}`
+	stmts, diagnostics := parse(t, input)
+	expectDiagnostics(t, diagnostics, nil)
+	constraint := stmts[0].(ast.ConstraintStmt)
+	if expected := "Creates a payment.\n\nCharged at once."; constraint.Doc != expected {
+		t.Errorf("Doc = %q, want %q", constraint.Doc, expected)
+	}
+	var docs []string
+	for _, assert := range constraint.AssertStmts {
+		docs = append(docs, assert.Doc)
+	}
+	if expected := []string{"Amount in cents.\nMust be positive.", ""}; !slices.Equal(docs, expected) {
+		t.Errorf("Docs = %q, want %q", docs, expected)
+	}
+	// the doc comments are kept as comments for the printer
+	if comments := constraint.AssertStmts[0].Comments; len(comments) != 4 {
+		t.Errorf("Comments = %v, want 4 comments", comments)
 	}
 }
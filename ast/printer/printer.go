@@ -0,0 +1,320 @@
+package printer
+
+import (
+	"customs/ast"
+	"customs/ast/parser"
+	"slices"
+	"strings"
+)
+
+const indent = "    "
+
+type Printer struct {
+	Stmts []ast.Stmt
+	// Comments are printed after the last statement
+	Comments []ast.Comment
+	b        strings.Builder
+	depth    int
+}
+
+func NewPrinter(stmts []ast.Stmt) *Printer {
+	return &Printer{Stmts: stmts}
+}
+
+// Print renders the statements back into canonical source text.
+func (r *Printer) Print() string {
+	r.b.Reset()
+	for i, stmt := range r.Stmts {
+		// constraints are separated from their surroundings by a blank line
+		if i > 0 && (isConstraint(stmt) || isConstraint(r.Stmts[i-1])) {
+			r.b.WriteString("\n")
+		}
+		stmt.Accept(r)
+	}
+	if len(r.Comments) > 0 && !r.Comments[0].Trailing && len(r.Stmts) > 0 && isConstraint(r.Stmts[len(r.Stmts)-1]) {
+		r.b.WriteString("\n")
+	}
+	r.comments(r.Comments)
+	return r.b.String()
+}
+
+func (r *Printer) line(s string) {
+	r.b.WriteString(strings.Repeat(indent, r.depth) + s + "\n")
+}
+
+// comments prints each comment on a line of its own, the lines of a block comment are kept as written.
+// A trailing comment stays at the end of the line printed before it.
+func (r *Printer) comments(comments []ast.Comment) {
+	for _, comment := range comments {
+		if !comment.Trailing || !r.trail(comment.Text) {
+			r.line(comment.Text)
+		}
+	}
+}
+
+// trail appends a comment to the last line printed, the blank lines after it are kept.
+func (r *Printer) trail(comment string) bool {
+	s := r.b.String()
+	last := strings.TrimRight(s, "\n")
+	if last == "" {
+		return false
+	}
+	r.b.Reset()
+	r.b.WriteString(last + " " + comment + s[len(last):])
+	return true
+}
+
+// annotations prints each annotation on a line of its own, the parentheses are dropped when there are no arguments.
+func (r *Printer) annotations(annotations []ast.Annotation) {
+	for _, annotation := range annotations {
+		if len(annotation.Args) == 0 {
+			r.line("@" + annotation.Name.Literal)
+			continue
+		}
+		r.line("@" + annotation.Name.Literal + "(" + printExprs(annotation.Args) + ")")
+	}
+}
+
+func (r *Printer) VisitAssignStmt(stmt ast.AssignStmt) {
+	r.comments(stmt.Comments)
+	r.line("let " + stmt.Id.Literal + " = " + PrintExpr(stmt.Expr) + ";")
+}
+
+func (r *Printer) VisitEnumStmt(stmt ast.EnumStmt) {
+	r.comments(stmt.Comments)
+	r.line("enum " + stmt.Id.Literal + " { " + printExprs(stmt.Values) + " }")
+}
+
+func (r *Printer) VisitConstraintStmt(stmt ast.ConstraintStmt) {
+	r.comments(stmt.Comments)
+	r.annotations(stmt.Annotations)
+	header := "constraint " + stmt.Id.Literal
+	if stmt.IsAbstract {
+		header = "abstract " + header
+	}
+	if stmt.ParentConstraint.Literal != "" {
+		header += " extends " + stmt.ParentConstraint.Literal
+		if len(stmt.LetStmts) == 0 && len(stmt.AssertStmts) == 0 && len(stmt.WhenStmts) == 0 && len(stmt.EndComments) == 0 {
+			r.line(header + ";")
+			return
+		}
+	}
+	r.line(header + " {")
+	r.depth++
+	// the lets, asserts and guards are kept apart by the parser, they are printed back in source order
+	stmts := make([]ast.Stmt, 0, len(stmt.LetStmts)+len(stmt.AssertStmts)+len(stmt.WhenStmts))
+	for _, let := range stmt.LetStmts {
+		stmts = append(stmts, let)
+	}
+	for _, assert := range stmt.AssertStmts {
+		stmts = append(stmts, assert)
+	}
+	for _, when := range stmt.WhenStmts {
+		stmts = append(stmts, when)
+	}
+	slices.SortStableFunc(stmts, func(a, b ast.Stmt) int { return offset(a) - offset(b) })
+	for _, nested := range stmts {
+		nested.Accept(r)
+	}
+	r.comments(stmt.EndComments)
+	r.depth--
+	r.line("}")
+}
+
+// offset returns where a statement of a constraint starts in the source.
+func offset(stmt ast.Stmt) int {
+	switch stmt := stmt.(type) {
+	case ast.AssignStmt:
+		return stmt.Id.DebugInfo.Offset
+	case ast.WhenStmt:
+		return stmt.Keyword.DebugInfo.Offset
+	case ast.AssertStmt:
+		switch {
+		case len(stmt.Path) > 0:
+			return stmt.Path[0].DebugInfo.Offset
+		case len(stmt.Fields) > 0:
+			return stmt.Fields[0].DebugInfo.Offset
+		}
+		return stmt.Id.DebugInfo.Offset
+	}
+	return 0
+}
+
+func (r *Printer) VisitWhenStmt(stmt ast.WhenStmt) {
+	r.comments(stmt.Comments)
+	r.line("when " + PrintExpr(stmt.Guard) + " {")
+	r.depth++
+	for _, assert := range stmt.AssertStmts {
+		assert.Accept(r)
+	}
+	r.comments(stmt.EndComments)
+	r.depth--
+	r.line("}")
+}
+
+func (r *Printer) VisitAssertStmt(stmt ast.AssertStmt) {
+	r.comments(stmt.Comments)
+	r.annotations(stmt.Annotations)
+	header := "assert "
+	for _, modifier := range stmt.Modifiers {
+		header += modifier.Literal + " "
+	}
+	for _, segment := range stmt.Path {
+		header += segment.Text() + "."
+	}
+	header += stmt.Id.Text()
+	if stmt.Type.Literal != "" {
+		header += ": " + stmt.Type.Literal
+	}
+	if stmt.Format.Literal != "" {
+		header += " format " + stmt.Format.Literal
+	}
+	if stmt.Default != nil {
+		header += " default " + PrintExpr(stmt.Default)
+	}
+	if len(stmt.Fields) > 0 {
+		fields := make([]string, 0, len(stmt.Fields))
+		for _, field := range stmt.Fields {
+			fields = append(fields, field.Text())
+		}
+		header += "(" + strings.Join(fields, ", ") + ")"
+	}
+	if stmt.Alias.Literal != "" && stmt.Default != nil {
+		header += " as " + stmt.Alias.Literal
+	} else if stmt.Alias.Literal != "" {
+		header += " (" + stmt.Alias.Literal + ")"
+	}
+	if stmt.Quantifier.Literal != "" {
+		header += " " + stmt.Quantifier.Literal + " (" + stmt.Element.Literal + ")"
+	}
+	if len(stmt.Exprs) == 0 && len(stmt.Stmts) == 0 && stmt.Declares() && !hasComments(stmt) {
+		r.line(header + ";")
+		return
+	}
+	if len(stmt.Exprs) == 1 && len(stmt.Stmts) == 0 && !hasComments(stmt) {
+		r.line(header + " => " + PrintExpr(stmt.Exprs[0]) + printViolation(stmt.Violation(0)) + ";")
+		return
+	}
+	r.line(header + " => {")
+	r.depth++
+	for i, expr := range stmt.Exprs {
+		if i < len(stmt.ExprComments) {
+			r.comments(stmt.ExprComments[i])
+		}
+		r.line(PrintExpr(expr) + printViolation(stmt.Violation(i)) + ";")
+	}
+	for _, nested := range stmt.Stmts {
+		nested.Accept(r)
+	}
+	r.comments(stmt.EndComments)
+	r.depth--
+	r.line("}")
+}
+
+// PrintExpr renders an expression, parentheses are only kept where the precedence requires them.
+func PrintExpr(expr ast.Expr) string {
+	switch v := expr.(type) {
+	case ast.Token:
+		return v.Text()
+	case ast.UnaryExpr:
+		operand := PrintExpr(v.Expr)
+		if precedence(v.Expr) < precedence(v) {
+			operand = "(" + operand + ")"
+		}
+		// `not x` is spelled as a keyword, `!x` as an operator
+		if v.Op.Literal == "not" {
+			return v.Op.Literal + " " + operand
+		}
+		return v.Op.Literal + operand
+	case ast.CallExpr:
+		return v.Callee.Literal + "(" + printExprs(v.Args) + ")"
+	case ast.ListExpr:
+		return "[" + printExprs(v.Elements) + "]"
+	case ast.MemberExpr:
+		return PrintExpr(v.Expr) + "." + v.Name.Text()
+	case ast.UniqueExpr:
+		expr := PrintExpr(v.Expr)
+		if precedence(v.Expr) <= precedence(v) {
+			expr = "(" + expr + ")"
+		}
+		if v.By.Literal != "" {
+			return expr + " unique by " + v.By.Text()
+		}
+		return expr + " unique"
+	case ast.IsExpr:
+		expr := PrintExpr(v.Expr)
+		if precedence(v.Expr) <= precedence(v) {
+			expr = "(" + expr + ")"
+		}
+		if v.Not {
+			return expr + " is not " + v.Predicate.Literal
+		}
+		return expr + " is " + v.Predicate.Literal
+	case ast.BinaryExpr:
+		left, right := PrintExpr(v.Left), PrintExpr(v.Right)
+		op := parser.Operators[v.Op.TokenType]
+		if p := precedence(v.Left); p < op.Precedence || (p == op.Precedence && op.Associativity != parser.LeftAssociative) {
+			left = "(" + left + ")"
+		}
+		if p := precedence(v.Right); p < op.Precedence || (p == op.Precedence && op.Associativity != parser.RightAssociative) {
+			right = "(" + right + ")"
+		}
+		return left + " " + v.Op.Literal + " " + right
+	}
+	return ""
+}
+
+// printViolation renders the message and the code following an expression, ` else "message" code "CODE"`.
+func printViolation(violation ast.Violation) string {
+	var printed string
+	if violation.Message.TokenType == ast.Value {
+		printed += " else " + violation.Message.Text()
+	}
+	if violation.Code.TokenType == ast.Value {
+		printed += " code " + violation.Code.Text()
+	}
+	return printed
+}
+
+// printExprs renders a comma separated list of expressions.
+func printExprs(exprs []ast.Expr) string {
+	printed := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		printed = append(printed, PrintExpr(expr))
+	}
+	return strings.Join(printed, ", ")
+}
+
+// precedence mirrors the binding power the parser gives to an expression, values bind the tightest.
+func precedence(expr ast.Expr) int {
+	switch v := expr.(type) {
+	case ast.IsExpr:
+		return parser.Operators[ast.Is].Precedence
+	case ast.UniqueExpr:
+		return parser.Operators[ast.Unique].Precedence
+	case ast.BinaryExpr:
+		return parser.Operators[v.Op.TokenType].Precedence
+	case ast.UnaryExpr:
+		p, _ := parser.PrefixPrecedence(v.Op)
+		return p
+	}
+	return 100
+}
+
+// hasComments reports whether an assert has comments inside its body, which only a block can keep.
+func hasComments(stmt ast.AssertStmt) bool {
+	if len(stmt.EndComments) > 0 {
+		return true
+	}
+	for _, comments := range stmt.ExprComments {
+		if len(comments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isConstraint(stmt ast.Stmt) bool {
+	_, ok := stmt.(ast.ConstraintStmt)
+	return ok
+}
@@ -0,0 +1,304 @@
+package printer
+
+import (
+	"customs/ast/parser"
+	"customs/ast/scanner"
+	"testing"
+)
+
+func TestPrinter_Print(t *testing.T) {
+	input := `let x = (1 + 2) * 3 - (4 - 5);
+	constraint   RegisterApi {
+	let y = x;
+	assert token as t => t > y;
+	};`
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := `let x = (1 + 2) * 3 - (4 - 5);
+
+constraint RegisterApi {
+    let y = x;
+    assert token (t) => t > y;
+}
+`
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintAssertBlock(t *testing.T) {
+	input := `constraint RegisterApi { assert token as t => { t > 1 else "too small"; t < 100 code "T_LARGE"; assert id => id > 0; } assert usage => { usage > 0 else "usage must exceed {min}" code "USAGE"; }; }`
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := `constraint RegisterApi {
+    assert token (t) => {
+        t > 1 else "too small";
+        t < 100 code "T_LARGE";
+        assert id => id > 0;
+    }
+    assert usage => usage > 0 else "usage must exceed {min}" code "USAGE";
+}
+`
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintComments(t *testing.T) {
+	input := `// the threshold
+let x = 10;
+/* the api */
+/// Registers a user.
+constraint RegisterApi {
+	///Checked first.
+	assert token as t => {
+	// long enough
+	t > x;
+	// closing
+	}
+	assert name => /* inline */ name is not empty;
+	// end
+}
+// eof`
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := `// the threshold
+let x = 10;
+
+/* the api */
+/// Registers a user.
+constraint RegisterApi {
+    ///Checked first.
+    assert token (t) => {
+        // long enough
+        t > x;
+        // closing
+    }
+    /* inline */
+    assert name => name is not empty;
+    // end
+}
+
+// eof
+`
+	printer := NewPrinter(stmts)
+	printer.Comments = p.Comments
+	if got := printer.Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintSourceOrder(t *testing.T) {
+	input := `let limit = 10; // the page size
+constraint ListApi { /* paging */
+    assert page => page > 0; // first page is 1
+    let max = limit * 2;
+    when page > 1 {
+        assert required cursor; // from the previous page
+    }
+    // the size of a page
+    assert size => size <= max;
+    let min = 1; /* at least one */ /* or more */
+    assert optional sort;
+} // done
+`
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := `let limit = 10; // the page size
+
+constraint ListApi { /* paging */
+    assert page => page > 0; // first page is 1
+    let max = limit * 2;
+    when page > 1 {
+        assert required cursor; // from the previous page
+    }
+    // the size of a page
+    assert size => size <= max;
+    let min = 1; /* at least one */ /* or more */
+    assert optional sort;
+} // done
+`
+	printer := NewPrinter(stmts)
+	printer.Comments = p.Comments
+	got := printer.Print()
+	if got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+	// printing the printed source gives it back
+	lexer = scanner.NewLexer(got)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p = parser.NewParser(lexer.Tokens)
+	if stmts, err = p.Parse(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	printer = NewPrinter(stmts)
+	printer.Comments = p.Comments
+	if again := printer.Print(); again != got {
+		t.Errorf("Print() = %s, want %s", again, got)
+	}
+}
+
+func TestPrinter_PrintExpr(t *testing.T) {
+	tests := map[string]string{
+		`(a > 1) and ((b < 2))`:              `a > 1 and b < 2`,
+		`(a or b) and c`:                     `(a or b) and c`,
+		`a or (b and c)`:                     `a or b and c`,
+		`a - (b - c) - (d * e)`:              `a - (b - c) - d * e`,
+		`not (a or b)`:                       `not (a or b)`,
+		`!(a > 1) or (not a) == b`:           `!(a > 1) or (not a) == b`,
+		`-(a + b) % 2`:                       `-(a + b) % 2`,
+		`(a + 1 > 2) and (x is null)`:        `a + 1 > 2 and x is null`,
+		`len( (name) ) <= min(1,(2 + 3))`:    `len(name) <= min(1, 2 + 3)`,
+		`(a not in [1,(2),]) or a in b`:      `a not in [1, 2] or a in b`,
+		`(i . qty > 0) and (a or b) unique`:  `i.qty > 0 and (a or b) unique`,
+		`i.meta."content-type" unique by id`: `i.meta."content-type" unique by id`,
+	}
+	for input, expected := range tests {
+		lexer := scanner.NewLexer(input + ";")
+		if err := lexer.Scan(); err != nil {
+			t.Fatalf("Error = %v\n", err)
+		}
+		p := parser.NewParser(lexer.Tokens)
+		expr, err := p.ParseExpr()
+		if err != nil {
+			t.Fatalf("Error = %v\n", err)
+		}
+		if got := PrintExpr(expr); got != expected {
+			t.Errorf("PrintExpr(%q) = %s, want %s", input, got, expected)
+		}
+	}
+}
+
+func TestPrinter_PrintFieldNames(t *testing.T) {
+	input := "constraint RegisterApi { assert user . `address`.zip2 (z) => z > 0; assert \"content-type\" as c => c != \"\"; assert ( start ,`end`)=>start<1; assert items as l each(i)=>{i.qty>0;} assert email : string format email => {} assert at:date - time(a)=>a>\"2024\"; assert required  nullable id:uuid default null; assert optional page default 1+1=>page>0; assert cursor default first  as c=>c!=\"\"; }"
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := "constraint RegisterApi {\n" +
+		"    assert user.`address`.zip2 (z) => z > 0;\n" +
+		"    assert \"content-type\" (c) => c != \"\";\n" +
+		"    assert (start, `end`) => start < 1;\n" +
+		"    assert items (l) each (i) => i.qty > 0;\n" +
+		"    assert email: string format email;\n" +
+		"    assert at: date-time (a) => a > \"2024\";\n" +
+		"    assert required nullable id: uuid default null;\n" +
+		"    assert optional page default 1 + 1 => page > 0;\n" +
+		"    assert cursor default first as c => c != \"\";\n" +
+		"}\n"
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintEnum(t *testing.T) {
+	input := "enum Currency {\"EUR\",\n\"USD\",};\nconstraint RegisterApi { assert currency => currency in Currency; }"
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := "enum Currency { \"EUR\", \"USD\" }\n" +
+		"\n" +
+		"constraint RegisterApi {\n" +
+		"    assert currency => currency in Currency;\n" +
+		"}\n"
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintWhen(t *testing.T) {
+	input := "constraint RegisterApi { when (kind == \"card\") { // the card\n assert card_number => card_number is not empty; }; assert kind => kind is not empty; }"
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := "constraint RegisterApi {\n" +
+		"    when kind == \"card\" { // the card\n" +
+		"        assert card_number => card_number is not empty;\n" +
+		"    }\n" +
+		"    assert kind => kind is not empty;\n" +
+		"}\n"
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
+
+func TestPrinter_PrintAnnotations(t *testing.T) {
+	input := "// the payments\n@deprecated( \"use v2\" ) @since(\"2024-03\") abstract constraint PayApi { @severity(warning) @example(40+2) assert amount => amount > 0; @deprecated() assert optional currency; }"
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	expected := "// the payments\n" +
+		"@deprecated(\"use v2\")\n" +
+		"@since(\"2024-03\")\n" +
+		"abstract constraint PayApi {\n" +
+		"    @severity(warning)\n" +
+		"    @example(40 + 2)\n" +
+		"    assert amount => amount > 0;\n" +
+		"    @deprecated\n" +
+		"    assert optional currency;\n" +
+		"}\n"
+	if got := NewPrinter(stmts).Print(); got != expected {
+		t.Errorf("Print() = %s, want %s", got, expected)
+	}
+}
@@ -1,37 +1,72 @@
 package scanner
 
-import v2 "customs/ast"
+import (
+	v2 "customs/ast"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
 type Lexer struct {
-	Text    string
-	Tokens  []v2.Token
+	Text        string
+	Tokens      []v2.Token
+	Diagnostics v2.Diagnostics
+	// current is the byte offset of the rune being scanned, line and column its position in runes
 	current int
+	line    int
+	column  int
+	trivia  []v2.Comment
 }
 
 func NewLexer(text string) *Lexer {
-	return &Lexer{Text: text, Tokens: make([]v2.Token, 0), current: 0}
+	return &Lexer{Text: text, Tokens: make([]v2.Token, 0), current: 0, line: 1, column: 1}
 }
 
 func (r *Lexer) IsEof() bool {
 	return r.current >= len(r.Text)
 }
 
+// This returns the rune being scanned, invalid UTF-8 is returned as utf8.RuneError.
 func (r *Lexer) This() rune {
 	if r.IsEof() {
 		return rune(0)
 	}
-	return rune(r.Text[r.current])
+	c, _ := utf8.DecodeRuneInString(r.Text[r.current:])
+	return c
 }
 
+// Peek returns the rune after the one being scanned, or zero at the end of the text.
 func (r *Lexer) Peek() rune {
 	if r.IsEof() {
 		return rune(0)
 	}
-	return rune(r.Text[r.current+1])
+	_, size := utf8.DecodeRuneInString(r.Text[r.current:])
+	if r.current+size >= len(r.Text) {
+		return rune(0)
+	}
+	c, _ := utf8.DecodeRuneInString(r.Text[r.current+size:])
+	return c
 }
 
+// Advance moves past the rune being scanned and keeps track of its line and column.
 func (r *Lexer) Advance() {
-	r.current += 1
+	if r.IsEof() {
+		return
+	}
+	c, size := utf8.DecodeRuneInString(r.Text[r.current:])
+	r.current += size
+	if c == '\n' {
+		r.line, r.column = r.line+1, 1
+	} else {
+		r.column += 1
+	}
+}
+
+// Position returns the line, column and byte offset of the rune being scanned.
+func (r *Lexer) Position() v2.DebugInfo {
+	return v2.DebugInfo{Line: r.line, Column: r.column, Offset: r.current}
 }
 
 func (r *Lexer) IsDigit() bool {
@@ -46,132 +81,291 @@ func (r *Lexer) IsString() bool {
 	return r.This() == '"'
 }
 
+// ScanString scans a string opened by the current quote and returns its value. Escapes of double
+// quoted strings are decoded, a string enclosed in backticks is raw and keeps every character as
+// written. Both can span several lines, Windows line endings are read as a single newline.
+func (r *Lexer) ScanString() string {
+	start, quote := r.Position(), r.This()
+	var b strings.Builder
+	r.Advance()
+	for !r.IsEof() && r.This() != quote {
+		switch {
+		case r.This() == '\r' && r.Peek() == '\n':
+			r.Advance()
+		case r.This() == '\\' && quote == '"':
+			at := r.Position()
+			c, multibyte, tail, err := strconv.UnquoteChar(r.Text[r.current:], byte(quote))
+			if err != nil {
+				r.Advance()
+				r.Diagnostics = append(r.Diagnostics, v2.NewDiagnostic(v2.InvalidEscape, v2.Span{Start: at, End: r.Position()},
+					"Invalid escape sequence '\\%c'", r.This()))
+				r.Advance()
+				continue
+			}
+			for end := len(r.Text) - len(tail); r.current < end; {
+				r.Advance()
+			}
+			// a byte escape above 0x7F is not a character on its own, the string would not be valid UTF-8
+			if c >= utf8.RuneSelf && !multibyte {
+				escape := r.Text[at.Offset:r.current]
+				r.Diagnostics = append(r.Diagnostics, v2.NewDiagnostic(v2.InvalidEscape, v2.Span{Start: at, End: r.Position()},
+					"Invalid escape sequence '%s', a byte escape must be below 0x80", escape).
+					WithFix(v2.Fix{Message: "escape the character instead", Span: v2.Span{Start: at, End: r.Position()}, Replacement: fmt.Sprintf("\\u%04X", c)}))
+				continue
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteRune(r.This())
+			r.Advance()
+		}
+	}
+	if r.IsEof() {
+		r.Diagnostics = append(r.Diagnostics, v2.NewDiagnostic(v2.UnterminatedString, v2.Span{Start: start, End: r.Position()},
+			"String is not terminated, expected '%c'", quote))
+		return b.String()
+	}
+	// consume the closing quote
+	r.Advance()
+	return b.String()
+}
+
+var (
+	decimal     = regexp.MustCompile(`^[0-9](_?[0-9])*(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	hexadecimal = regexp.MustCompile(`^0[xX]_?[0-9a-fA-F](_?[0-9a-fA-F])*$`)
+)
+
+// ScanNumber scans a number such as 42, 1_000_000, 0xFF, 2.5 or 1e-3 and returns its value in
+// decimal notation. Everything which looks like a number is consumed, so that `1.2.3` or `12ab`
+// are reported as a whole and the value is Any.
+func (r *Lexer) ScanNumber() (string, v2.LiteralType) {
+	start := r.Position()
+	for r.IsDigit() || r.IsLetter() || r.This() == '.' {
+		// the sign of an exponent belongs to the number
+		hex := strings.HasPrefix(strings.ToLower(r.Text[start.Offset:r.current]), "0x")
+		if c := r.This(); (c == 'e' || c == 'E') && (r.Peek() == '+' || r.Peek() == '-') && !hex {
+			r.Advance()
+		}
+		r.Advance()
+	}
+	text := r.Text[start.Offset:r.current]
+	malformed := func(format string) (string, v2.LiteralType) {
+		r.Diagnostics = append(r.Diagnostics, v2.NewDiagnostic(v2.MalformedNumber, v2.Span{Start: start, End: r.Position()}, format, text))
+		return text, v2.Any
+	}
+	digits := strings.ReplaceAll(text, "_", "")
+	switch {
+	case hexadecimal.MatchString(text):
+		v, err := strconv.ParseInt(digits, 0, 64)
+		if err != nil {
+			return malformed("Number %s is out of range")
+		}
+		return strconv.FormatInt(v, 10), v2.Integer
+	case decimal.MatchString(text) && !strings.ContainsAny(text, ".eE"):
+		if _, err := strconv.ParseInt(digits, 10, 64); err != nil {
+			return malformed("Number %s is out of range")
+		}
+		return digits, v2.Integer
+	case decimal.MatchString(text):
+		if _, err := strconv.ParseFloat(digits, 64); err != nil {
+			return malformed("Number %s is out of range")
+		}
+		return digits, v2.Float
+	}
+	return malformed("Malformed number %s")
+}
+
+// add appends a token, the comments scanned since the previous token become its trivia.
+func (r *Lexer) add(token v2.Token) {
+	token.Trivia, r.trivia = r.trivia, nil
+	r.Tokens = append(r.Tokens, token)
+}
+
+// comment keeps a comment as trivia of the next token, it is trailing when it follows a token
+// on its line and only blanks or other comments follow it.
+func (r *Lexer) comment(text string, pos v2.DebugInfo) {
+	rest, _, _ := strings.Cut(r.Text[r.current:], "\n")
+	rest = strings.TrimSpace(rest)
+	trailing := len(r.Tokens) > 0 && r.Tokens[len(r.Tokens)-1].DebugInfo.Line == pos.Line &&
+		(len(r.trivia) == 0 || r.trivia[len(r.trivia)-1].Trailing) &&
+		(rest == "" || strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "/*"))
+	r.trivia = append(r.trivia, v2.Comment{Text: text, DebugInfo: pos, Trailing: trailing})
+}
+
+// Scan tokenizes the whole text, invalid characters are reported and skipped.
 func (r *Lexer) Scan() (err error) {
-	var line, column = 1, 1
+	// a byte order mark is not part of the text
+	if strings.HasPrefix(r.Text[r.current:], "\uFEFF") {
+		r.current += len("\uFEFF")
+	}
 	for !r.IsEof() {
+		pos := r.Position()
+		token := func(tokenType v2.TokenType, literal string, literalType v2.LiteralType) {
+			r.add(v2.Token{Literal: literal, LiteralType: literalType, TokenType: tokenType, DebugInfo: pos})
+		}
 		switch r.This() {
-		case '\n':
-			line = line + 1
-			column = 1
-			r.Advance()
-			continue
-		case ' ':
+		case '\n', ' ', '\t', '\r':
 			r.Advance()
 			continue
 		case '+':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.Plus, "+", v2.Any, line, column))
+			token(v2.Plus, "+", v2.Any)
 		case '-':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.Minus, "-", v2.Any, line, column))
+			token(v2.Minus, "-", v2.Any)
 		case '*':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.Multiply, "*", v2.Any, line, column))
+			token(v2.Multiply, "*", v2.Any)
 		case '/':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.Divide, "/", v2.Any, line, column))
+			switch r.Peek() {
+			case '/':
+				for !r.IsEof() && r.This() != '\n' {
+					r.Advance()
+				}
+				r.comment(strings.TrimRight(r.Text[pos.Offset:r.current], "\r"), pos)
+				continue
+			case '*':
+				r.Advance()
+				r.Advance()
+				for !r.IsEof() && !strings.HasPrefix(r.Text[r.current:], "*/") {
+					r.Advance()
+				}
+				if r.IsEof() {
+					r.Diagnostics = append(r.Diagnostics, v2.NewDiagnostic(v2.UnterminatedComment, v2.Span{Start: pos, End: pos}, "Comment is not terminated, expected '*/'"))
+				} else {
+					r.Advance()
+					r.Advance()
+				}
+				r.comment(strings.ReplaceAll(r.Text[pos.Offset:r.current], "\r\n", "\n"), pos)
+				continue
+			}
+			token(v2.Divide, "/", v2.Any)
 		case '(':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.LeftParen, "(", v2.Any, line, column))
+			token(v2.LeftParen, "(", v2.Any)
 		case ')':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.RightParen, ")", v2.Any, line, column))
+			token(v2.RightParen, ")", v2.Any)
 		case '{':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.LeftBrace, "{", v2.Any, line, column))
+			token(v2.LeftBrace, "{", v2.Any)
 		case '}':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.RightBrace, "}", v2.Any, line, column))
+			token(v2.RightBrace, "}", v2.Any)
 		case ';':
-			r.Tokens = append(r.Tokens, v2.NewToken(v2.Semicolon, ";", v2.Any, line, column))
+			token(v2.Semicolon, ";", v2.Any)
+		case '[':
+			token(v2.LeftBracket, "[", v2.Any)
+		case ']':
+			token(v2.RightBracket, "]", v2.Any)
+		case ',':
+			token(v2.Comma, ",", v2.Any)
+		case '.':
+			token(v2.Dot, ".", v2.Any)
+		case ':':
+			token(v2.Colon, ":", v2.Any)
+		case '@':
+			token(v2.At, "@", v2.Any)
+		case '%':
+			token(v2.Modulo, "%", v2.Any)
+		case '!':
+			if r.Peek() == '=' {
+				token(v2.NotEqual, "!=", v2.Any)
+				r.Advance()
+			} else {
+				token(v2.Not, "!", v2.Any)
+			}
 		case '=':
 			switch r.Peek() {
 			case '=':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.Equal, "==", v2.Any, line, column))
+				token(v2.Equal, "==", v2.Any)
 				r.Advance()
 			case '>':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.Arrow, "=>", v2.Any, line, column))
-				r.Advance()
-			case ' ':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.Assign, "=", v2.Any, line, column))
+				token(v2.Arrow, "=>", v2.Any)
 				r.Advance()
 			default:
-				err = v2.InvalidTokenErr(line, column)
-				return
+				token(v2.Assign, "=", v2.Any)
 			}
 		case '>':
-			switch r.Peek() {
-			case '=':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.GreaterThanOrEqual, ">=", v2.Any, line, column))
+			if r.Peek() == '=' {
+				token(v2.GreaterThanOrEqual, ">=", v2.Any)
 				r.Advance()
-			case ' ':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.GreaterThan, ">", v2.Any, line, column))
-				r.Advance()
-			default:
-				err = v2.InvalidTokenErr(line, column)
-				return
+			} else {
+				token(v2.GreaterThan, ">", v2.Any)
 			}
 		case '<':
-			switch r.Peek() {
-			case '=':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.LessThanOrEqual, "<=", v2.Any, line, column))
-				r.Advance()
-			case ' ':
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.LessThan, "<", v2.Any, line, column))
+			if r.Peek() == '=' {
+				token(v2.LessThanOrEqual, "<=", v2.Any)
 				r.Advance()
-			default:
-				err = v2.InvalidTokenErr(line, column)
-				return
+			} else {
+				token(v2.LessThan, "<", v2.Any)
 			}
 		default:
 			if r.IsDigit() {
-				start := r.current
-				dots := false
-				for (r.IsDigit() || r.This() == '.') && !r.IsEof() {
-					if r.This() == '.' {
-						dots = true
-					}
-					r.Advance()
-				}
-				if dots {
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Value, r.Text[start:r.current], v2.Float, line, column))
-				} else {
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Value, r.Text[start:r.current], v2.Integer, line, column))
-				}
+				literal, typ := r.ScanNumber()
+				r.add(v2.Token{Literal: literal, LiteralType: typ, TokenType: v2.Value, DebugInfo: pos, Raw: r.Text[pos.Offset:r.current]})
 				continue
 			}
 			if r.IsLetter() {
-				start := r.current
-				for r.IsLetter() && !r.IsEof() {
+				for (r.IsLetter() || r.IsDigit()) && !r.IsEof() {
 					r.Advance()
 				}
-				switch txt := r.Text[start:r.current]; txt {
-				case "let":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Let, txt, v2.Any, line, column))
-				case "assert":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Assert, txt, v2.Any, line, column))
-				case "constraint":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Constraint, txt, v2.Any, line, column))
-				case "abstract":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Abstract, txt, v2.Any, line, column))
-				case "is":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Is, txt, v2.Any, line, column))
-				case "extends":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Extends, txt, v2.Any, line, column))
-				case "as":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.As, txt, v2.Any, line, column))
-				case "not":
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Not, txt, v2.Any, line, column))
-				default:
-					r.Tokens = append(r.Tokens, v2.NewToken(v2.Ident, txt, v2.Any, line, column))
+				txt := r.Text[pos.Offset:r.current]
+				if keyword, ok := keywords[txt]; ok {
+					typ, ok := literalTypes[txt]
+					if !ok {
+						typ = v2.Any
+					}
+					token(keyword, txt, typ)
+				} else {
+					token(v2.Ident, txt, v2.Any)
 				}
 				continue
 			}
-			if r.IsString() {
-				start := r.current
-				r.Advance()
-				for !r.IsString() && !r.IsEof() {
-					r.Advance()
-				}
-				r.Tokens = append(r.Tokens, v2.NewToken(v2.Value, r.Text[start:r.current+1], v2.String, line, column))
+			if r.IsString() || r.This() == '`' {
+				value := r.ScanString()
+				r.add(v2.Token{Literal: value, LiteralType: v2.String, TokenType: v2.Value, DebugInfo: pos, Raw: r.Text[pos.Offset:r.current]})
 				continue
 			}
+			r.Diagnostics = append(r.Diagnostics, v2.InvalidTokenErr(pos, r.This()))
 		}
-		column = column + 1
 		r.Advance()
 	}
-	r.Tokens = append(r.Tokens, v2.NewToken(v2.Eof, "Eof", v2.Any, line, column))
+	r.add(v2.Token{Literal: "Eof", LiteralType: v2.Any, TokenType: v2.Eof, DebugInfo: r.Position()})
+	err = r.Diagnostics.Err()
 	return
 }
+
+// literalTypes types the keywords which are values
+var literalTypes = map[string]v2.LiteralType{
+	"true":  v2.Boolean,
+	"false": v2.Boolean,
+	"null":  v2.Nil,
+}
+
+var keywords = map[string]v2.TokenType{
+	"let":        v2.Let,
+	"assert":     v2.Assert,
+	"constraint": v2.Constraint,
+	"abstract":   v2.Abstract,
+	"is":         v2.Is,
+	"extends":    v2.Extends,
+	"as":         v2.As,
+	"not":        v2.Not,
+	"contains":   v2.Contains,
+	"startsWith": v2.StartsWith,
+	"endsWith":   v2.EndsWith,
+	"matches":    v2.Matches,
+	"in":         v2.In,
+	"enum":       v2.Enum,
+	"when":       v2.When,
+	"each":       v2.Each,
+	"all":        v2.All,
+	"any":        v2.Exists,
+	"unique":     v2.Unique,
+	"by":         v2.By,
+	"format":     v2.Format,
+	"required":   v2.Required,
+	"optional":   v2.Optional,
+	"nullable":   v2.Nullable,
+	"default":    v2.Default,
+	"else":       v2.Else,
+	"and":        v2.And,
+	"or":         v2.Or,
+	"empty":      v2.Empty,
+	"null":       v2.Null,
+	"true":       v2.Value,
+	"false":      v2.Value,
+}
@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	v2 "customs/ast"
 	"fmt"
 	"testing"
 )
@@ -24,3 +25,200 @@ func TestLexer_Scan2(t *testing.T) {
 	}
 	fmt.Printf("%v\n", lexer.Tokens)
 }
+
+func TestLexer_ScanComments(t *testing.T) {
+	input := `// the threshold
+let x = 10 / 2; /* block
+comment */ let`
+	lexer := NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	let := lexer.Tokens[0]
+	if len(let.Trivia) != 1 || let.Trivia[0].Text != "// the threshold" || let.Trivia[0].IsBlock() {
+		t.Errorf("Trivia = %v, want the line comment", let.Trivia)
+	}
+	if divide := lexer.Tokens[4]; divide.TokenType != v2.Divide {
+		t.Errorf("Tokens[4] = %v, want Divide", divide)
+	}
+	next := lexer.Tokens[7]
+	if len(next.Trivia) != 1 || next.Trivia[0].Text != "/* block\ncomment */" || !next.Trivia[0].IsBlock() {
+		t.Errorf("Trivia = %v, want the block comment", next.Trivia)
+	}
+	if next.DebugInfo.Line != 3 {
+		t.Errorf("Line = %d, want 3", next.DebugInfo.Line)
+	}
+	// a comment is trailing when it ends the line of the token before it
+	if let.Trivia[0].Trailing || next.Trivia[0].Trailing {
+		t.Errorf("Trailing = true, want false for a comment alone on its line or followed by a token")
+	}
+}
+
+func TestLexer_ScanUnterminatedComment(t *testing.T) {
+	lexer := NewLexer(`let x = 1; /* open`)
+	if err := lexer.Scan(); err == nil {
+		t.Fatalf("Scan() succeeded, want an unterminated comment")
+	}
+	if len(lexer.Diagnostics) != 1 || lexer.Diagnostics[0].Code != v2.UnterminatedComment {
+		t.Errorf("Diagnostics = %v, want %s", lexer.Diagnostics, v2.UnterminatedComment)
+	}
+}
+
+func TestLexer_ScanUnicode(t *testing.T) {
+	input := "let s = \"héllo, 世界\";\r\n\tlet x = 1; // ünïcode\r\nx"
+	lexer := NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	if s := lexer.Tokens[3]; s.Literal != "héllo, 世界" || s.Raw != `"héllo, 世界"` {
+		t.Errorf("Literal = %q, Raw = %q, want the whole string", s.Literal, s.Raw)
+	}
+	expected := []struct {
+		index   int
+		literal string
+		info    v2.DebugInfo
+	}{
+		{4, ";", v2.DebugInfo{Line: 1, Column: 20, Offset: 24}},
+		{5, "let", v2.DebugInfo{Line: 2, Column: 2, Offset: 28}},
+		{8, "1", v2.DebugInfo{Line: 2, Column: 10, Offset: 36}},
+		{10, "x", v2.DebugInfo{Line: 3, Column: 1, Offset: 53}},
+		{11, "Eof", v2.DebugInfo{Line: 3, Column: 2, Offset: 54}},
+	}
+	for _, e := range expected {
+		token := lexer.Tokens[e.index]
+		if token.Literal != e.literal || token.DebugInfo != e.info {
+			t.Errorf("Tokens[%d] = %q at %+v, want %q at %+v", e.index, token.Literal, token.DebugInfo, e.literal, e.info)
+		}
+	}
+	if comment := lexer.Tokens[10].Trivia; len(comment) != 1 || comment[0].Text != "// ünïcode" || !comment[0].Trailing {
+		t.Errorf("Trivia = %v, want the comment without the carriage return", comment)
+	}
+}
+
+func TestLexer_ScanInvalidRune(t *testing.T) {
+	lexer := NewLexer("let x = 1;\n\tlet é")
+	if err := lexer.Scan(); err == nil {
+		t.Fatalf("Scan() succeeded, want an invalid token")
+	}
+	if len(lexer.Diagnostics) != 1 || lexer.Diagnostics[0].Error() != `[2:6] E002 Invalid token 'é'` {
+		t.Errorf("Diagnostics = %v, want a single invalid token at [2:6]", lexer.Diagnostics)
+	}
+	// the end of input can be peeked at without reading past the text
+	if r := NewLexer("=").Peek(); r != 0 {
+		t.Errorf("Peek() = %q, want 0", r)
+	}
+}
+
+func TestLexer_ScanStrings(t *testing.T) {
+	input := "\"say \\\"hi\\\"\\n\\u00e9\\t\\\\\" `^\\d+\\.\\d*$` \"two\r\nlines\" `raw\nlines`"
+	lexer := NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	expected := []struct{ literal, raw string }{
+		{"say \"hi\"\né\t\\", `"say \"hi\"\n\u00e9\t\\"`},
+		{`^\d+\.\d*$`, "`^\\d+\\.\\d*$`"},
+		{"two\nlines", "\"two\r\nlines\""},
+		{"raw\nlines", "`raw\nlines`"},
+	}
+	for i, e := range expected {
+		token := lexer.Tokens[i]
+		if token.LiteralType != v2.String || token.Literal != e.literal || token.Raw != e.raw {
+			t.Errorf("Tokens[%d] = %q (%q), want %q (%q)", i, token.Literal, token.Raw, e.literal, e.raw)
+		}
+	}
+	// the token after a multi-line string is on the following line
+	if last := lexer.Tokens[3]; last.DebugInfo.Line != 2 || v2.TokenSpan(last).End.Line != 3 {
+		t.Errorf("Tokens[3] = %v, want a string from line 2 to line 3", last)
+	}
+}
+
+func TestLexer_ScanInvalidStrings(t *testing.T) {
+	lexer := NewLexer("let p = \"\\d\";\nlet b = \"\\x7f\\xff\";\nlet s = \"open;")
+	if err := lexer.Scan(); err == nil {
+		t.Fatalf("Scan() succeeded, want invalid strings")
+	}
+	expected := []string{
+		`[1:10] E013 Invalid escape sequence '\d'`,
+		`[2:14] E013 Invalid escape sequence '\xff', a byte escape must be below 0x80`,
+		`[3:9] E012 String is not terminated, expected '"'`,
+	}
+	if len(lexer.Diagnostics) != len(expected) {
+		t.Fatalf("Diagnostics = %v, want %d", lexer.Diagnostics, len(expected))
+	}
+	for i, e := range lexer.Diagnostics {
+		if e.Error() != expected[i] {
+			t.Errorf("Diagnostics[%d] = %q, want %q", i, e, expected[i])
+		}
+	}
+	if fix := lexer.Diagnostics[1].Fix; fix == nil || fix.Replacement != `\u00FF` {
+		t.Errorf("Diagnostics[1].Fix = %v, want replacement \\u00FF", fix)
+	}
+	if value := lexer.Tokens[8].Literal; value != "\x7f" {
+		t.Errorf("Tokens[8] = %q, want the valid escapes decoded", value)
+	}
+}
+
+func TestLexer_ScanOperators(t *testing.T) {
+	input := `t>5 and t<=10 or !(t!=3)%2==1 not x>=y=>z=1<2 in[a,] each all any unique by x:format required optional nullable default else @`
+	lexer := NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	expected := []v2.TokenType{
+		v2.Ident, v2.GreaterThan, v2.Value, v2.And, v2.Ident, v2.LessThanOrEqual, v2.Value, v2.Or,
+		v2.Not, v2.LeftParen, v2.Ident, v2.NotEqual, v2.Value, v2.RightParen, v2.Modulo, v2.Value, v2.Equal, v2.Value,
+		v2.Not, v2.Ident, v2.GreaterThanOrEqual, v2.Ident, v2.Arrow, v2.Ident, v2.Assign, v2.Value, v2.LessThan, v2.Value,
+		v2.In, v2.LeftBracket, v2.Ident, v2.Comma, v2.RightBracket, v2.Each, v2.All, v2.Exists, v2.Unique, v2.By,
+		v2.Ident, v2.Colon, v2.Format, v2.Required, v2.Optional, v2.Nullable, v2.Default, v2.Else, v2.At, v2.Eof,
+	}
+	if len(lexer.Tokens) != len(expected) {
+		t.Fatalf("Tokens = %v, want %d tokens", lexer.Tokens, len(expected))
+	}
+	for i, typ := range expected {
+		if lexer.Tokens[i].TokenType != typ {
+			t.Errorf("Tokens[%d] = %v, want %v", i, lexer.Tokens[i], typ)
+		}
+	}
+}
+
+func TestLexer_ScanNumbers(t *testing.T) {
+	input := `42 1_000_000 0xFF 0X_1f 2.5 1e-3 6.02E+23 1_0.0_1 true false null`
+	lexer := NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	expected := []struct {
+		literal string
+		typ     v2.LiteralType
+	}{
+		{"42", v2.Integer}, {"1000000", v2.Integer}, {"255", v2.Integer}, {"31", v2.Integer},
+		{"2.5", v2.Float}, {"1e-3", v2.Float}, {"6.02E+23", v2.Float}, {"10.01", v2.Float},
+		{"true", v2.Boolean}, {"false", v2.Boolean}, {"null", v2.Nil},
+	}
+	for i, e := range expected {
+		token := lexer.Tokens[i]
+		if token.Literal != e.literal || token.LiteralType != e.typ {
+			t.Errorf("Tokens[%d] = %v, want %s %s", i, token, e.literal, e.typ)
+		}
+	}
+	if raw := lexer.Tokens[2].Text(); raw != "0xFF" {
+		t.Errorf("Text() = %s, want 0xFF", raw)
+	}
+}
+
+func TestLexer_ScanMalformedNumbers(t *testing.T) {
+	for _, input := range []string{"1.2.3", "1__0", "10_", "0x", "0xG", "1e", "1e+", "12ab", "1.", "99999999999999999999"} {
+		lexer := NewLexer(input + ";")
+		if err := lexer.Scan(); err == nil {
+			t.Errorf("Scan(%q) succeeded, want a malformed number", input)
+			continue
+		}
+		if d := lexer.Diagnostics[0]; d.Code != v2.MalformedNumber || d.Span.End.Column != len(input)+1 {
+			t.Errorf("Scan(%q) = %v, want %s over the whole number", input, d, v2.MalformedNumber)
+		}
+		if len(lexer.Tokens) != 3 || lexer.Tokens[1].TokenType != v2.Semicolon {
+			t.Errorf("Scan(%q) = %v, want the number to be consumed", input, lexer.Tokens)
+		}
+	}
+}
@@ -2,12 +2,19 @@ package ast
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type StmtVisitor interface {
 	VisitAssignStmt(AssignStmt)
 	VisitConstraintStmt(ConstraintStmt)
 	VisitAssertStmt(AssertStmt)
+	VisitEnumStmt(EnumStmt)
+	VisitWhenStmt(WhenStmt)
 }
 
 type Stmt interface {
@@ -15,8 +22,9 @@ type Stmt interface {
 }
 
 type AssignStmt struct {
-	Id   Token
-	Expr Expr
+	Id       Token
+	Expr     Expr
+	Comments []Comment
 }
 
 func (r AssignStmt) String() string {
@@ -27,12 +35,39 @@ func (r AssignStmt) Accept(v StmtVisitor) {
 	v.VisitAssignStmt(r)
 }
 
+// EnumStmt declares a named list of allowed values, `enum Status { "active", "pending" }`.
+type EnumStmt struct {
+	Id       Token
+	Values   []Expr
+	Comments []Comment
+}
+
+func (r EnumStmt) String() string {
+	values := make([]string, 0, len(r.Values))
+	for _, v := range r.Values {
+		values = append(values, PrefixTraversal(v))
+	}
+	return fmt.Sprintf("EnumStmt{%s [%s]}", r.Id, strings.Join(values, " "))
+}
+
+func (r EnumStmt) Accept(v StmtVisitor) {
+	v.VisitEnumStmt(r)
+}
+
+// ConstraintStmt and AssertStmt keep the comments written before them in Comments,
+// and those written before their closing brace in EndComments. Doc is the text of the `///` comments
+// written before them, which documents them in the generated schema.
 type ConstraintStmt struct {
+	Doc              string
+	Annotations      []Annotation
 	IsAbstract       bool
 	Id               Token
 	ParentConstraint Token
 	LetStmts         []AssignStmt
 	AssertStmts      []AssertStmt
+	WhenStmts        []WhenStmt
+	Comments         []Comment
+	EndComments      []Comment
 }
 
 func (r ConstraintStmt) String() string {
@@ -43,21 +78,160 @@ func (r ConstraintStmt) Accept(v StmtVisitor) {
 	v.VisitConstraintStmt(r)
 }
 
+// Asserts returns the asserts of the constraint followed by those guarded by its when statements,
+// all of them describe fields of the same request.
+func (r ConstraintStmt) Asserts() []AssertStmt {
+	asserts := slices.Clip(r.AssertStmts)
+	for _, when := range r.WhenStmts {
+		asserts = append(asserts, when.AssertStmts...)
+	}
+	return asserts
+}
+
+// WhenStmt guards asserts with a condition on the request, `when type == "card" { ... }`,
+// the asserts only apply to the requests for which the guard holds.
+type WhenStmt struct {
+	Keyword     Token
+	Guard       Expr
+	AssertStmts []AssertStmt
+	Comments    []Comment
+	EndComments []Comment
+}
+
+func (r WhenStmt) String() string {
+	return fmt.Sprintf("WhenStmt{%s %v}", PrefixTraversal(r.Guard), r.AssertStmts)
+}
+
+func (r WhenStmt) Accept(v StmtVisitor) {
+	v.VisitWhenStmt(r)
+}
+
+// ExprComments holds the comments of each expression of a block body, in the order of Exprs.
+// Path holds the leading fields of a dotted assert such as `assert user.address.zip`, it is a
+// shorthand for asserts nested in each of those fields, Id being the last one.
+// Fields holds the fields of a cross-field assert such as `assert (start, end)`, Id is then empty.
+// Quantifier is the each, all or any keyword of an assert on the elements of a list, `assert items each (i)`,
+// and Element the name given to the elements. Type and Format are declared after the field, `assert email: string format email`.
+// Modifiers are the required, optional and nullable keywords written before the field and Default the value after `default`.
+// Violations holds the message and the code of each expression, in the order of Exprs.
 type AssertStmt struct {
-	Id    Token
-	Alias Token
-	Exprs []Expr
-	Stmts []AssertStmt
+	Doc          string
+	Annotations  []Annotation
+	Modifiers    []Token
+	Path         []Token
+	Id           Token
+	Type         Token
+	Format       Token
+	Default      Expr
+	Fields       []Token
+	Quantifier   Token
+	Element      Token
+	Alias        Token
+	Exprs        []Expr
+	Violations   []Violation
+	Stmts        []AssertStmt
+	Comments     []Comment
+	ExprComments [][]Comment
+	EndComments  []Comment
 }
 
 func (r AssertStmt) String() string {
-	return fmt.Sprintf("AssertStmt{%s %s %v}", r.Id, r.Alias, r.Exprs)
+	return fmt.Sprintf("AssertStmt{%s %s %v %v}", r.Id, r.Alias, r.Exprs, r.Stmts)
 }
 
 func (r AssertStmt) Accept(v StmtVisitor) {
 	v.VisitAssertStmt(r)
 }
 
+// Declares reports whether the assert describes its field without a body, `assert required id: uuid;`.
+func (r AssertStmt) Declares() bool {
+	return r.Type.Literal != "" || len(r.Modifiers) > 0 || r.Default != nil
+}
+
+// Modifier reports whether the field is marked with the modifier.
+func (r AssertStmt) Modifier(typ TokenType) bool {
+	for _, modifier := range r.Modifiers {
+		if modifier.TokenType == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Violation returns the message and the code reported when the i-th expression does not hold.
+func (r AssertStmt) Violation(i int) Violation {
+	if i < len(r.Violations) {
+		return r.Violations[i]
+	}
+	return Violation{}
+}
+
+// Violation is reported when an expression of an assert does not hold, `t > 0 else "t must be positive" code "T_NEGATIVE"`.
+// Message and Code are strings, either may be omitted.
+type Violation struct {
+	Message Token
+	Code    Token
+}
+
+// Annotation attaches metadata to the constraint or the assert which follows it, `@deprecated("use v2")`.
+// Args is empty when the parentheses are omitted, `@deprecated`.
+type Annotation struct {
+	At   Token
+	Name Token
+	Args []Expr
+}
+
+func (r Annotation) String() string {
+	args := make([]string, 0, len(r.Args))
+	for _, arg := range r.Args {
+		args = append(args, PrefixTraversal(arg))
+	}
+	return fmt.Sprintf("@%s(%s)", r.Name.Literal, strings.Join(args, " "))
+}
+
+// Placeholder matches a `{name}` placeholder of a violation message, it is replaced by the value of the let it names.
+var Placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Siblings returns the fields of the object at path, the root being the request. Asserts on the same
+// object are merged, whether their fields are nested in a block or written as a dotted path.
+func Siblings(asserts []AssertStmt, path []string) []Token {
+	var fields []Token
+	for _, assert := range asserts {
+		if len(assert.Fields) > 0 {
+			if len(path) == 0 {
+				fields = append(fields, assert.Fields...)
+			}
+			continue
+		}
+		full := append(append([]Token{}, assert.Path...), assert.Id)
+		i := 0
+		for i < len(full) && i < len(path) && full[i].Literal == path[i] {
+			i++
+		}
+		switch {
+		case i == len(path) && i < len(full):
+			fields = append(fields, full[i])
+		case i == len(full):
+			fields = append(fields, Siblings(assert.Stmts, path[i:])...)
+		}
+	}
+	return fields
+}
+
+// PascalCase turns a request field such as extra_info or content-type into ExtraInfo, its key in the generated schema.
+func PascalCase(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(c rune) bool { return c == '_' || c == '-' || c == ' ' }) {
+		if part == "" {
+			continue
+		}
+		first, size := utf8.DecodeRuneInString(part)
+		b.WriteRune(unicode.ToUpper(first))
+		b.WriteString(part[size:])
+	}
+	return b.String()
+}
+
 func PrefixTraversal(expr Expr) string {
 	switch v := expr.(type) {
 	case Token:
@@ -66,6 +240,30 @@ func PrefixTraversal(expr Expr) string {
 		return v.Op.Literal + "(" + PrefixTraversal(v.Expr) + ")"
 	case BinaryExpr:
 		return "(" + v.Op.Literal + " " + PrefixTraversal(v.Left) + " " + PrefixTraversal(v.Right) + ")"
+	case IsExpr:
+		if v.Not {
+			return "(is not " + PrefixTraversal(v.Expr) + " " + v.Predicate.Literal + ")"
+		}
+		return "(is " + PrefixTraversal(v.Expr) + " " + v.Predicate.Literal + ")"
+	case CallExpr:
+		args := make([]string, 0, len(v.Args))
+		for _, arg := range v.Args {
+			args = append(args, PrefixTraversal(arg))
+		}
+		return v.Callee.Literal + "(" + strings.Join(args, " ") + ")"
+	case ListExpr:
+		elements := make([]string, 0, len(v.Elements))
+		for _, e := range v.Elements {
+			elements = append(elements, PrefixTraversal(e))
+		}
+		return "[" + strings.Join(elements, " ") + "]"
+	case MemberExpr:
+		return PrefixTraversal(v.Expr) + "." + v.Name.Literal
+	case UniqueExpr:
+		if v.By.Literal != "" {
+			return "(unique " + PrefixTraversal(v.Expr) + " " + v.By.Literal + ")"
+		}
+		return "(unique " + PrefixTraversal(v.Expr) + ")"
 	}
 	return ""
 }
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 )
 
 type TokenType int
@@ -14,6 +15,9 @@ type Token struct {
 	TokenType   TokenType
 	DebugInfo   DebugInfo
 	Precedence  float64
+	Trivia      []Comment
+	// Raw is the text of a string or a number as written in the source, its Literal holds the value
+	Raw string
 }
 
 func NewToken(tokenType TokenType, literal string, literalType LiteralType, line, column int) Token {
@@ -25,13 +29,52 @@ func NewToken(tokenType TokenType, literal string, literalType LiteralType, line
 	}
 }
 
+// Text returns the token as written in the source.
+func (r Token) Text() string {
+	if r.Raw != "" {
+		return r.Raw
+	}
+	return r.Literal
+}
+
 func (r Token) String() string {
 	return fmt.Sprintf("%s{%s %s %v}", r.TokenType, r.Literal, r.LiteralType, r.DebugInfo)
 }
 
+// Comment is a `//` or `/* */` comment, the lexer keeps it as trivia of the token which follows it.
+// A trailing comment ends the line of the token before it, `let x = 1; // note`.
+type Comment struct {
+	Text      string
+	DebugInfo DebugInfo
+	Trailing  bool
+}
+
+func (r Comment) IsBlock() bool {
+	return strings.HasPrefix(r.Text, "/*")
+}
+
+// IsDoc reports whether the comment is a `///` doc comment, a line of `////` is an ordinary comment.
+func (r Comment) IsDoc() bool {
+	return strings.HasPrefix(r.Text, "///") && !strings.HasPrefix(r.Text, "////")
+}
+
+// Doc returns the text of the doc comments, a line for each without its `///` marker.
+func Doc(comments []Comment) string {
+	var lines []string
+	for _, comment := range comments {
+		if comment.IsDoc() {
+			line := strings.TrimPrefix(comment.Text, "///")
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DebugInfo is a position in the source, Line and Column count runes from 1 and Offset counts bytes from 0.
 type DebugInfo struct {
 	Line   int
 	Column int
+	Offset int
 }
 
 func (r *DebugInfo) String() string {
@@ -43,6 +86,8 @@ const (
 	Float
 	String
 	Boolean
+	Nil
+	List
 	Any // Undefined
 )
 
@@ -56,6 +101,10 @@ func (r LiteralType) String() string {
 		return "String"
 	case Boolean:
 		return "Boolean"
+	case Nil:
+		return "Null"
+	case List:
+		return "List"
 	case Any:
 		return "Any"
 	}
@@ -78,18 +127,47 @@ const (
 	Minus
 	Multiply
 	Divide
+	Modulo
 	And
 	Or
 	LeftParen
 	RightParen
 	LeftBrace
 	RightBrace
+	LeftBracket
+	RightBracket
 	As
 	Is
 	Not
+	Empty
+	Null
+	Contains
+	StartsWith
+	EndsWith
+	Matches
+	In
+	NotIn
+	Enum
+	When
+	Each
+	All
+	// Exists is the `any` quantifier
+	Exists
+	Unique
+	By
+	Format
+	Required
+	Optional
+	Nullable
+	Default
+	Else
 	Assign
 	Arrow
 	Semicolon
+	Comma
+	Colon
+	Dot
+	At
 	Value
 	Ident
 	Eof
@@ -127,6 +205,8 @@ func (r TokenType) String() string {
 		return "Multiply"
 	case Divide:
 		return "Divide"
+	case Modulo:
+		return "Modulo"
 	case And:
 		return "And"
 	case Or:
@@ -139,18 +219,72 @@ func (r TokenType) String() string {
 		return "LeftBrace"
 	case RightBrace:
 		return "RightBrace"
+	case LeftBracket:
+		return "LeftBracket"
+	case RightBracket:
+		return "RightBracket"
 	case As:
 		return "As"
 	case Is:
 		return "Is"
 	case Not:
 		return "Not"
+	case Empty:
+		return "Empty"
+	case Null:
+		return "Null"
+	case Contains:
+		return "Contains"
+	case StartsWith:
+		return "StartsWith"
+	case EndsWith:
+		return "EndsWith"
+	case Matches:
+		return "Matches"
+	case In:
+		return "In"
+	case NotIn:
+		return "NotIn"
+	case Enum:
+		return "Enum"
+	case When:
+		return "When"
+	case Each:
+		return "Each"
+	case All:
+		return "All"
+	case Exists:
+		return "Exists"
+	case Unique:
+		return "Unique"
+	case By:
+		return "By"
+	case Format:
+		return "Format"
+	case Required:
+		return "Required"
+	case Optional:
+		return "Optional"
+	case Nullable:
+		return "Nullable"
+	case Default:
+		return "Default"
+	case Else:
+		return "Else"
+	case Colon:
+		return "Colon"
 	case Assign:
 		return "Assign"
 	case Arrow:
 		return "Arrow"
 	case Semicolon:
 		return "Semicolon"
+	case Comma:
+		return "Comma"
+	case Dot:
+		return "Dot"
+	case At:
+		return "At"
 	case Value:
 		return "Value"
 	case Ident:
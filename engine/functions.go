@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"customs/ast"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Functions folds the builtins of ast.Builtins, the analyzer has already checked their arguments.
+var Functions = map[string]func(args []Value) (interface{}, ast.LiteralType){
+	"len": func(args []Value) (interface{}, ast.LiteralType) {
+		s, ok := args[0].Val.(string)
+		if !ok {
+			return nil, ast.Any
+		}
+		return utf8.RuneCountInString(s), ast.Integer
+	},
+	"count": func(args []Value) (interface{}, ast.LiteralType) {
+		l, ok := args[0].Val.([]interface{})
+		if !ok {
+			return nil, ast.Any
+		}
+		return len(l), ast.Integer
+	},
+	"lower": func(args []Value) (interface{}, ast.LiteralType) {
+		s, ok := args[0].Val.(string)
+		if !ok {
+			return nil, ast.Any
+		}
+		return strings.ToLower(s), ast.String
+	},
+	"upper": func(args []Value) (interface{}, ast.LiteralType) {
+		s, ok := args[0].Val.(string)
+		if !ok {
+			return nil, ast.Any
+		}
+		return strings.ToUpper(s), ast.String
+	},
+	"abs": func(args []Value) (interface{}, ast.LiteralType) {
+		switch v := args[0].Val.(type) {
+		case int:
+			if v < 0 {
+				return -v, ast.Integer
+			}
+			return v, ast.Integer
+		case float64:
+			return math.Abs(v), ast.Float
+		}
+		return nil, ast.Any
+	},
+	"min": func(args []Value) (interface{}, ast.LiteralType) {
+		return extremum(args, func(a, b float64) bool { return a < b })
+	},
+	"max": func(args []Value) (interface{}, ast.LiteralType) {
+		return extremum(args, func(a, b float64) bool { return a > b })
+	},
+}
+
+// extremum returns the argument which is better than all the others, it is a float if any argument is.
+func extremum(args []Value, better func(a, b float64) bool) (interface{}, ast.LiteralType) {
+	best, typ := args[0], ast.Integer
+	for _, arg := range args {
+		if !isNumber(arg.Typ) {
+			return nil, ast.Any
+		}
+		if arg.Typ == ast.Float {
+			typ = ast.Float
+		}
+		if better(toFloat(arg.Val), toFloat(best.Val)) {
+			best = arg
+		}
+	}
+	if typ == ast.Float {
+		return toFloat(best.Val), ast.Float
+	}
+	return best.Val, ast.Integer
+}
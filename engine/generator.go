@@ -3,53 +3,678 @@ package engine
 import (
 	"customs/ast"
 	"gopkg.in/yaml.v2"
-	"os"
+	"slices"
+	"strings"
 )
 
-type Yaml struct {
-	Data map[string]map[string][]map[string]int `yaml:",inline"`
-}
-
 type Generator struct {
 	Resolver *Resolver
 	Stmts    []ast.Stmt
+	// Diagnostics warns about the assertions which cannot be rendered as rules and are left out of the schema
+	Diagnostics ast.Diagnostics
+	reported    map[string]bool
+	// asserts are those of the constraint being generated and path the object of the assert being generated,
+	// siblings are the other fields of that object and shared the fields of a cross-field assert
+	asserts  []ast.AssertStmt
+	path     []string
+	siblings map[string]bool
+	shared   map[string]bool
 }
 
 func NewGenerator(resolver *Resolver, stmts []ast.Stmt) Generator {
-	return Generator{Resolver: resolver, Stmts: stmts}
+	return Generator{Resolver: resolver, Stmts: stmts, reported: make(map[string]bool)}
 }
 
-func (r *Generator) GenerateYaml() {
-	y := Yaml{Data: make(map[string]map[string][]map[string]int)}
+// report records a diagnostic once, inherited asserts are generated again in every child.
+func (r *Generator) report(d ast.Diagnostic) {
+	if key := d.Error(); !r.reported[key] {
+		r.reported[key] = true
+		r.Diagnostics = append(r.Diagnostics, d)
+	}
+}
 
+// GenerateYaml renders every concrete constraint as a mapping of request fields to their rules,
+// fields and rules keep the order in which they were declared.
+func (r *Generator) GenerateYaml() ([]byte, error) {
+	y := yaml.MapSlice{}
 	for _, stmt := range r.Stmts {
-		switch stmt := stmt.(type) {
-		case ast.ConstraintStmt:
-			y.Data[stmt.Ident.Literal] = make(map[string][]map[string]int)
-			for _, blockStmt := range stmt.Block {
-				if assertStmt, ok := blockStmt.(ast.AssertStmt); ok {
-					for _, exp := range assertStmt.Exps {
-						if binaryExp, ok := exp.(ast.BinaryExpr); ok {
-							right, typ := r.Resolver.ComputeExpr(binaryExp.Right)
-							if typ == ast.Integer {
-								y.Data[stmt.Ident.Literal][assertStmt.Ident.Literal] =
-									append(
-										y.Data[stmt.Ident.Literal][assertStmt.Ident.Literal],
-										map[string]int{binaryExp.Operator.Literal: right.(int)})
-							}
-						}
+		// only concrete constraints are rendered, abstract ones live on in their children
+		if stmt, ok := stmt.(ast.ConstraintStmt); ok && !stmt.IsAbstract {
+			y = append(y, yaml.MapItem{Key: stmt.Id.Literal, Value: r.GenerateConstraint(stmt)})
+		}
+	}
+	return yaml.Marshal(y)
+}
+
+// Field is the schema of a request field, the rules it must satisfy and the fields nested in it.
+type Field struct {
+	Name   string
+	Rules  []yaml.MapSlice
+	Fields []*Field
+}
+
+// Field returns the nested field with the given name, fields asserted more than once share their rules.
+func (r *Field) Field(name string) *Field {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	f := &Field{Name: name, Rules: make([]yaml.MapSlice, 0)}
+	r.Fields = append(r.Fields, f)
+	return f
+}
+
+// Yaml renders a leaf as its list of rules and an object as a mapping of its nested fields,
+// the rules of an object which has both are listed under _Rules. Field names never contain an underscore
+// once in PascalCase, so the keys which are not fields start with one.
+func (r *Field) Yaml() interface{} {
+	if len(r.Fields) == 0 {
+		return r.Rules
+	}
+	m := yaml.MapSlice{}
+	if len(r.Rules) > 0 {
+		m = append(m, yaml.MapItem{Key: "_Rules", Value: r.Rules})
+	}
+	for _, f := range r.Fields {
+		m = append(m, yaml.MapItem{Key: f.Name, Value: f.Yaml()})
+	}
+	return m
+}
+
+func (r *Generator) GenerateConstraint(stmt ast.ConstraintStmt) yaml.MapSlice {
+	r.Resolver.Enter(stmt)
+	defer r.Resolver.Leave()
+
+	root := &Field{}
+	r.asserts, r.path = stmt.Asserts(), nil
+	for _, assertStmt := range stmt.AssertStmts {
+		r.GenerateAssert(root, assertStmt)
+	}
+	fields := yaml.MapSlice{}
+	if stmt.Doc != "" {
+		fields = append(fields, yaml.MapItem{Key: "_Description", Value: stmt.Doc})
+	}
+	if annotations := r.GenerateAnnotations(stmt.Annotations); len(annotations) > 0 {
+		fields = append(fields, yaml.MapItem{Key: "_Annotations", Value: annotations})
+	}
+	for _, f := range root.Fields {
+		fields = append(fields, yaml.MapItem{Key: f.Name, Value: f.Yaml()})
+	}
+	var whens []yaml.MapSlice
+	for _, whenStmt := range stmt.WhenStmts {
+		if when, ok := r.GenerateWhen(whenStmt); ok {
+			whens = append(whens, when)
+		}
+	}
+	if len(whens) > 0 {
+		fields = append(fields, yaml.MapItem{Key: "_When", Value: whens})
+	}
+	return fields
+}
+
+// GenerateWhen renders the rules the fields must satisfy for the guard to hold under If and the guarded
+// fields under Then. Each operand of a conjunction must be lowered into rules on a single field, otherwise
+// the guarded asserts are skipped and reported, applying them to every request would reject requests they do not concern.
+func (r *Generator) GenerateWhen(stmt ast.WhenStmt) (yaml.MapSlice, bool) {
+	r.path, r.siblings, r.shared = nil, make(map[string]bool), nil
+	fields := ast.Siblings(r.asserts, nil)
+	// a field which is only tested by the guard is not asserted, the constants are folded instead
+	for _, ident := range ast.Idents(stmt.Guard) {
+		if _, typ := r.Resolver.ComputeExpr(ident); typ == ast.Any {
+			fields = append(fields, ident)
+		}
+	}
+	for _, field := range fields {
+		r.siblings[field.Literal] = true
+	}
+	guard := &Field{}
+	for _, condition := range conjuncts(stmt.Guard) {
+		lowered := false
+		for _, field := range fields {
+			if rules, ok := r.lower(field.Literal, condition); ok {
+				f := guard.Field(ast.PascalCase(field.Literal))
+				f.Rules = append(f.Rules, rules...)
+				lowered = true
+				break
+			}
+		}
+		if !lowered {
+			r.report(ast.NewDiagnostic(ast.UnrenderedAssertion, ast.ExprSpan(condition), "Guard cannot be rendered as rules, its asserts are left out of the schema"))
+			return nil, false
+		}
+	}
+	then := &Field{}
+	for _, assertStmt := range stmt.AssertStmts {
+		r.GenerateAssert(then, assertStmt)
+	}
+	return yaml.MapSlice{{Key: "If", Value: guard.Yaml()}, {Key: "Then", Value: then.Yaml()}}, true
+}
+
+// GenerateAnnotations renders the annotations as a mapping of their names in PascalCase to their folded argument,
+// an annotation without arguments is true and one with several arguments the list of them.
+func (r *Generator) GenerateAnnotations(annotations []ast.Annotation) yaml.MapSlice {
+	m := yaml.MapSlice{}
+	for _, annotation := range annotations {
+		spec, ok := ast.Annotations[annotation.Name.Literal]
+		if !ok {
+			continue
+		}
+		values := make([]interface{}, 0, len(annotation.Args))
+		for _, arg := range annotation.Args {
+			// a choice is written as an identifier
+			if token, ok := arg.(ast.Token); ok && len(spec.Choices) > 0 {
+				values = append(values, token.Literal)
+				continue
+			}
+			if v, typ := r.Resolver.ComputeExpr(arg); typ != ast.Any {
+				values = append(values, v)
+			}
+		}
+		var value interface{} = values
+		switch {
+		case len(annotation.Args) == 0:
+			value = true
+		case len(values) != len(annotation.Args):
+			continue
+		case len(values) == 1:
+			value = values[0]
+		}
+		m = append(m, yaml.MapItem{Key: ast.PascalCase(annotation.Name.Literal), Value: value})
+	}
+	return m
+}
+
+// conjuncts splits `a and b and c` into its operands.
+func conjuncts(expr ast.Expr) []ast.Expr {
+	if binaryExpr, ok := expr.(ast.BinaryExpr); ok && binaryExpr.Op.TokenType == ast.And {
+		return append(conjuncts(binaryExpr.Left), conjuncts(binaryExpr.Right)...)
+	}
+	return []ast.Expr{expr}
+}
+
+func (r *Generator) GenerateAssert(parent *Field, stmt ast.AssertStmt) {
+	path := r.path
+	defer func() { r.path = path }()
+	for _, segment := range stmt.Path {
+		parent = parent.Field(ast.PascalCase(segment.Literal))
+		r.path = append(slices.Clip(r.path), segment.Literal)
+	}
+	r.siblings, r.shared = make(map[string]bool), make(map[string]bool)
+	for _, sibling := range ast.Siblings(r.asserts, r.path) {
+		r.siblings[sibling.Literal] = true
+	}
+	// every field of a cross-field assert gets the rules in which it is the subject
+	if len(stmt.Fields) > 0 {
+		subjects := make([]string, 0, len(stmt.Fields))
+		for _, field := range stmt.Fields {
+			r.shared[field.Literal] = true
+			subjects = append(subjects, field.Literal)
+		}
+		rules := r.GenerateExprs(subjects, stmt)
+		for _, field := range stmt.Fields {
+			// a field without rules, such as the right side of a relation, is not listed
+			if len(rules[field.Literal]) == 0 {
+				continue
+			}
+			f := parent.Field(ast.PascalCase(field.Literal))
+			f.Rules = append(f.Rules, rules[field.Literal]...)
+		}
+		return
+	}
+	delete(r.siblings, stmt.Id.Literal)
+	f := parent.Field(ast.PascalCase(stmt.Id.Literal))
+	if stmt.Doc != "" {
+		f.Rules = append(f.Rules, yaml.MapSlice{{Key: "Description", Value: stmt.Doc}})
+	}
+	if annotations := r.GenerateAnnotations(stmt.Annotations); len(annotations) > 0 {
+		f.Rules = append(f.Rules, yaml.MapSlice{{Key: "Annotations", Value: annotations}})
+	}
+	// a field marked or declared by several asserts lists the modifier or the type once
+	f.Rules = appendNew(f.Rules, modifiers(stmt)...)
+	f.Rules = appendNew(f.Rules, declaration(stmt)...)
+	// the default is folded like a let, a default which cannot be resolved is left out
+	if stmt.Default != nil {
+		if v, typ := r.Resolver.ComputeExpr(stmt.Default); typ != ast.Any {
+			f.Rules = append(f.Rules, yaml.MapSlice{{Key: "Default", Value: v}})
+		}
+	}
+	if stmt.Quantifier.Literal != "" {
+		if rule, ok := r.GenerateElement(stmt); ok {
+			f.Rules = append(f.Rules, rule)
+		}
+		return
+	}
+	field := stmt.Id.Literal
+	if stmt.Alias.Literal != "" {
+		field = stmt.Alias.Literal
+	}
+	f.Rules = append(f.Rules, r.GenerateExprs([]string{field}, stmt)[field]...)
+	r.path = append(slices.Clip(r.path), stmt.Id.Literal)
+	for _, nested := range stmt.Stmts {
+		r.GenerateAssert(f, nested)
+	}
+}
+
+// modifiers renders `required` as Required: true, `optional` as Required: false and `nullable` as Nullable: true,
+// a field without modifiers leaves them to the consumer of the schema.
+func modifiers(stmt ast.AssertStmt) []yaml.MapSlice {
+	var rules []yaml.MapSlice
+	switch {
+	case stmt.Modifier(ast.Required):
+		rules = append(rules, yaml.MapSlice{{Key: "Required", Value: true}})
+	case stmt.Modifier(ast.Optional):
+		rules = append(rules, yaml.MapSlice{{Key: "Required", Value: false}})
+	}
+	if stmt.Modifier(ast.Nullable) {
+		rules = append(rules, yaml.MapSlice{{Key: "Nullable", Value: true}})
+	}
+	return rules
+}
+
+// appendNew appends the rules which are not listed yet.
+func appendNew(rules []yaml.MapSlice, more ...yaml.MapSlice) []yaml.MapSlice {
+	for _, rule := range more {
+		if !slices.ContainsFunc(rules, func(r yaml.MapSlice) bool { return slices.Equal(r, rule) }) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// declaration renders `assert email: string format email` as Type: string and Format: email,
+// a format declared as the type is a string in that format.
+func declaration(stmt ast.AssertStmt) []yaml.MapSlice {
+	switch {
+	case stmt.Type.Literal == "":
+		return nil
+	case ast.Formats[stmt.Type.Literal]:
+		return []yaml.MapSlice{{{Key: "Type", Value: "string"}}, {{Key: "Format", Value: stmt.Type.Literal}}}
+	case stmt.Format.Literal != "":
+		return []yaml.MapSlice{{{Key: "Type", Value: stmt.Type.Literal}}, {{Key: "Format", Value: stmt.Format.Literal}}}
+	}
+	return []yaml.MapSlice{{{Key: "Type", Value: stmt.Type.Literal}}}
+}
+
+// GenerateElement renders the rules on the elements of a list under Each when every element must satisfy them,
+// and under Any when a single element is enough. The element and its fields are lowered like the fields of an object.
+func (r *Generator) GenerateElement(stmt ast.AssertStmt) (yaml.MapSlice, bool) {
+	// the other fields of the object are not fields of the element, the fields of the element are related to
+	// one another and a relation is rendered on its left field as in a cross-field assert
+	element := &Field{Rules: make([]yaml.MapSlice, 0)}
+	names := members(stmt.Element.Literal, stmt.Exprs)
+	r.siblings, r.shared = make(map[string]bool), make(map[string]bool)
+	for _, name := range names {
+		r.siblings[name], r.shared[name] = true, true
+	}
+	rules := r.GenerateExprs(names, stmt)
+	for _, name := range names {
+		f := element
+		for _, part := range strings.Split(name, ".")[1:] {
+			f = f.Field(ast.PascalCase(part))
+		}
+		f.Rules = append(f.Rules, rules[name]...)
+	}
+	if len(element.Rules) == 0 && len(element.Fields) == 0 {
+		return nil, false
+	}
+	key := "Each"
+	if stmt.Quantifier.TokenType == ast.Exists {
+		key = "Any"
+	}
+	return yaml.MapSlice{{Key: key, Value: element.Yaml()}}, true
+}
+
+// GenerateExprs lowers the expressions of an assert into rules on each of its subjects, the asserted field,
+// the fields of a cross-field assert or the members of an element. The message and the code of an expression
+// are added to each of its rules so that a validator can report them when the rule is not satisfied.
+// Every operand of a conjunction must be lowered on one of the subjects, the others are reported.
+func (r *Generator) GenerateExprs(subjects []string, stmt ast.AssertStmt) map[string][]yaml.MapSlice {
+	rules := make(map[string][]yaml.MapSlice, len(subjects))
+	for i, expr := range stmt.Exprs {
+		violation := stmt.Violation(i)
+		for _, condition := range conjuncts(expr) {
+			lowered := false
+			for _, subject := range subjects {
+				subjectRules, ok := r.lower(subject, condition)
+				for _, rule := range subjectRules {
+					if violation.Message.TokenType == ast.Value {
+						rule = append(rule, yaml.MapItem{Key: "Message", Value: r.Resolver.Interpolate(violation.Message.Literal)})
+					}
+					if violation.Code.TokenType == ast.Value {
+						rule = append(rule, yaml.MapItem{Key: "Code", Value: violation.Code.Literal})
 					}
+					rules[subject] = append(rules[subject], rule)
 				}
+				lowered = lowered || ok
 			}
+			if !lowered {
+				r.report(ast.NewDiagnostic(ast.UnrenderedAssertion, ast.ExprSpan(condition), "Assertion cannot be rendered as rules, it is left out of the schema"))
+			}
+		}
+	}
+	return rules
+}
+
+// lower renders a comparison between the field and a constant, another field of its object or the time
+// of the request as rules, and reports whether the whole expression was lowered. A conjunction keeps the rules
+// of its lowered operands, but a disjunction is only rendered as AnyOf when every branch was lowered,
+// dropping a branch would reject requests which satisfy the assert.
+func (r *Generator) lower(field string, expr ast.Expr) ([]yaml.MapSlice, bool) {
+	if isExpr, ok := expr.(ast.IsExpr); ok {
+		return lowerIsExpr(field, isExpr)
+	}
+	if uniqueExpr, ok := expr.(ast.UniqueExpr); ok {
+		return lowerUniqueExpr(field, uniqueExpr)
+	}
+	binaryExpr, ok := expr.(ast.BinaryExpr)
+	if !ok {
+		return nil, false
+	}
+	switch binaryExpr.Op.TokenType {
+	case ast.And:
+		left, l := r.lower(field, binaryExpr.Left)
+		right, k := r.lower(field, binaryExpr.Right)
+		return append(left, right...), l && k
+	case ast.Or:
+		left, l := r.lower(field, binaryExpr.Left)
+		right, k := r.lower(field, binaryExpr.Right)
+		if !l || !k {
+			return nil, false
+		}
+		return []yaml.MapSlice{{{Key: "AnyOf", Value: [][]yaml.MapSlice{left, right}}}}, true
+	}
+
+	op, subject, operand := binaryExpr.Op.TokenType, binaryExpr.Left, binaryExpr.Right
+	if other, ok := r.sibling(operand, field); ok && isField(subject, field) {
+		return relate(op, other)
+	}
+	if other, ok := r.sibling(subject, field); ok && isField(operand, field) {
+		// a relation between the fields of a cross-field assert is rendered on its left field
+		if _, ok := StringRules[op]; ok || r.shared[other] {
+			return nil, false
+		}
+		return relate(Mirror(op), other)
+	}
+	if !isField(subject, field) && !isMeasure(subject, field) {
+		if !isField(operand, field) && !isMeasure(operand, field) {
+			return nil, false
+		}
+		// string and membership operators cannot be mirrored, `"abc" contains name` does not describe name
+		if _, ok := StringRules[op]; ok || op == ast.In || op == ast.NotIn {
+			return nil, false
+		}
+		op, subject, operand = Mirror(op), operand, subject
+	}
+	name, ok := RuleNames[op]
+	if !ok {
+		return nil, false
+	}
+	// a comparison with the time of the request is rendered relative to it, `expires_at > now() + 60` as GtNow: 60
+	if offset, ok := r.nowOffset(operand); ok {
+		switch op {
+		case ast.GreaterThan, ast.GreaterThanOrEqual, ast.LessThan, ast.LessThanOrEqual:
+		default:
+			return nil, false
 		}
+		if isMeasure(subject, field) {
+			return nil, false
+		}
+		return []yaml.MapSlice{{{Key: name + "Now", Value: offset}}}, true
+	}
+	v, typ := r.Resolver.ComputeExpr(operand)
+	if typ == ast.Any {
+		return nil, false
+	}
+	if rule, ok := measure(subject, field); ok {
+		return lowerMeasure(rule, op, v, typ)
+	}
+	// a membership test needs the list of allowed values
+	if (op == ast.In || op == ast.NotIn) != (typ == ast.List) {
+		return nil, false
+	}
+	// a comparison with null is a null test
+	if typ == ast.Nil {
+		switch op {
+		case ast.Equal:
+			return []yaml.MapSlice{{{Key: "Null", Value: true}}}, true
+		case ast.NotEqual:
+			return []yaml.MapSlice{{{Key: "NotNull", Value: true}}}, true
+		}
+		return nil, false
+	}
+	return []yaml.MapSlice{{{Key: name, Value: v}}}, true
+}
+
+// nowOffset returns the offset in seconds of an expression from the time of the request,
+// 0 for `now()` and -3600 for `now() - 3600`.
+func (r *Generator) nowOffset(expr ast.Expr) (int, bool) {
+	switch expr := expr.(type) {
+	case ast.CallExpr:
+		return 0, expr.Callee.Literal == "now" && len(expr.Args) == 0
+	case ast.BinaryExpr:
+		sign := 1
+		switch expr.Op.TokenType {
+		case ast.Minus:
+			sign = -1
+		case ast.Plus:
+		default:
+			return 0, false
+		}
+		base, operand := expr.Left, expr.Right
+		if _, ok := r.nowOffset(base); !ok && sign == 1 {
+			base, operand = operand, base
+		}
+		offset, ok := r.nowOffset(base)
+		if !ok {
+			return 0, false
+		}
+		v, typ := r.Resolver.ComputeExpr(operand)
+		if typ != ast.Integer {
+			return 0, false
+		}
+		return offset + sign*v.(int), true
+	}
+	return 0, false
+}
+
+// sibling returns the name of the other field of the object an expression refers to,
+// the fields of an element such as `i.qty` and `i.price` are siblings when they belong to the same object.
+func (r *Generator) sibling(expr ast.Expr, field string) (string, bool) {
+	name, ok := fieldName(expr)
+	if !ok || name == field || !r.siblings[name] || parent(name) != parent(field) {
+		return "", false
+	}
+	return name, true
+}
+
+// parent returns the object a field of an element belongs to, `i` for `i.qty`.
+func parent(name string) string {
+	return name[:max(strings.LastIndex(name, "."), 0)]
+}
+
+// relate renders `password != username` as NeField: Username and `i.price > i.qty` as GtField: Qty.
+func relate(op ast.TokenType, other string) ([]yaml.MapSlice, bool) {
+	name, ok := RelationNames[op]
+	if !ok {
+		return nil, false
+	}
+	return []yaml.MapSlice{{{Key: name, Value: ast.PascalCase(other[strings.LastIndex(other, ".")+1:])}}}, true
+}
+
+// lowerIsExpr renders `usage is not empty` as NotEmpty: true and `id is integer` as Type: integer.
+func lowerIsExpr(field string, expr ast.IsExpr) ([]yaml.MapSlice, bool) {
+	if !isField(expr.Expr, field) {
+		return nil, false
+	}
+	prefix := ""
+	if expr.Not {
+		prefix = "Not"
+	}
+	switch expr.Predicate.TokenType {
+	case ast.Empty:
+		return []yaml.MapSlice{{{Key: prefix + "Empty", Value: true}}}, true
+	case ast.Null:
+		return []yaml.MapSlice{{{Key: prefix + "Null", Value: true}}}, true
 	}
+	return []yaml.MapSlice{{{Key: prefix + "Type", Value: expr.Predicate.Literal}}}, true
+}
+
+// lowerMeasure renders `len(name) <= 64` as MaxLength: 64 and `count(items) > 0` as MinCount: 1,
+// bounds are made inclusive.
+func lowerMeasure(rule string, op ast.TokenType, v interface{}, typ ast.LiteralType) ([]yaml.MapSlice, bool) {
+	n, ok := v.(int)
+	if !ok || typ != ast.Integer {
+		return nil, false
+	}
+	switch op {
+	case ast.Equal:
+		return []yaml.MapSlice{{{Key: rule, Value: n}}}, true
+	case ast.LessThanOrEqual:
+		return []yaml.MapSlice{{{Key: "Max" + rule, Value: n}}}, true
+	case ast.LessThan:
+		return []yaml.MapSlice{{{Key: "Max" + rule, Value: n - 1}}}, true
+	case ast.GreaterThanOrEqual:
+		return []yaml.MapSlice{{{Key: "Min" + rule, Value: n}}}, true
+	case ast.GreaterThan:
+		return []yaml.MapSlice{{{Key: "Min" + rule, Value: n + 1}}}, true
+	}
+	return nil, false
+}
 
-	d, err := yaml.Marshal(&y)
-	if err != nil {
-		panic(err)
+// lowerUniqueExpr renders `ids unique` as Unique: true and `items unique by id` as UniqueBy: Id.
+func lowerUniqueExpr(field string, expr ast.UniqueExpr) ([]yaml.MapSlice, bool) {
+	if !isField(expr.Expr, field) {
+		return nil, false
 	}
-	err = os.WriteFile("output.yaml", d, 0644)
-	if err != nil {
-		panic(err)
+	if expr.By.Literal != "" {
+		return []yaml.MapSlice{{{Key: "UniqueBy", Value: ast.PascalCase(expr.By.Literal)}}}, true
 	}
+	return []yaml.MapSlice{{{Key: "Unique", Value: true}}}, true
+}
+
+var RuleNames = map[ast.TokenType]string{
+	ast.Equal:              "Eq",
+	ast.NotEqual:           "Ne",
+	ast.GreaterThan:        "Gt",
+	ast.GreaterThanOrEqual: "Gte",
+	ast.LessThan:           "Lt",
+	ast.LessThanOrEqual:    "Lte",
+	ast.Contains:           "Contains",
+	ast.StartsWith:         "StartsWith",
+	ast.EndsWith:           "EndsWith",
+	ast.Matches:            "Matches",
+	ast.In:                 "Enum",
+	ast.NotIn:              "NotEnum",
+}
+
+// RelationNames are the rules comparing a field with another field of its object.
+var RelationNames = map[ast.TokenType]string{
+	ast.Equal:              "EqField",
+	ast.NotEqual:           "NeField",
+	ast.GreaterThan:        "GtField",
+	ast.GreaterThanOrEqual: "GteField",
+	ast.LessThan:           "LtField",
+	ast.LessThanOrEqual:    "LteField",
+	ast.Contains:           "ContainsField",
+	ast.StartsWith:         "StartsWithField",
+	ast.EndsWith:           "EndsWithField",
+}
+
+// StringRules are the rules which only apply to a string field.
+var StringRules = map[ast.TokenType]bool{
+	ast.Contains:   true,
+	ast.StartsWith: true,
+	ast.EndsWith:   true,
+	ast.Matches:    true,
+}
+
+// Mirror returns the comparison which holds once both operands are swapped.
+func Mirror(op ast.TokenType) ast.TokenType {
+	switch op {
+	case ast.GreaterThan:
+		return ast.LessThan
+	case ast.GreaterThanOrEqual:
+		return ast.LessThanOrEqual
+	case ast.LessThan:
+		return ast.GreaterThan
+	case ast.LessThanOrEqual:
+		return ast.GreaterThanOrEqual
+	}
+	return op
+}
+
+// Measures names the rules bounding the size of a field after the builtin which measures it.
+var Measures = map[string]string{
+	"len":   "Length",
+	"count": "Count",
+}
+
+// measure returns the rule of an expression measuring the field, Length for `len(name)` and Count for `count(items)`.
+func measure(expr ast.Expr, field string) (string, bool) {
+	call, ok := expr.(ast.CallExpr)
+	if !ok || len(call.Args) != 1 || !isField(call.Args[0], field) {
+		return "", false
+	}
+	rule, ok := Measures[call.Callee.Literal]
+	return rule, ok
+}
+
+func isMeasure(expr ast.Expr, field string) bool {
+	_, ok := measure(expr, field)
+	return ok
+}
+
+// fieldName returns the name of a field or the dotted path to a field of an element, `i.qty`.
+func fieldName(expr ast.Expr) (string, bool) {
+	switch v := expr.(type) {
+	case ast.Token:
+		return v.Literal, v.TokenType == ast.Ident
+	case ast.MemberExpr:
+		name, ok := fieldName(v.Expr)
+		return name + "." + v.Name.Literal, ok
+	}
+	return "", false
+}
+
+// members returns the element and the fields of the element the expressions refer to, in order of appearance.
+func members(element string, exprs []ast.Expr) []string {
+	var names []string
+	var walk func(expr ast.Expr)
+	walk = func(expr ast.Expr) {
+		if name, ok := fieldName(expr); ok {
+			if (name == element || strings.HasPrefix(name, element+".")) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+			return
+		}
+		switch v := expr.(type) {
+		case ast.BinaryExpr:
+			walk(v.Left)
+			walk(v.Right)
+		case ast.UnaryExpr:
+			walk(v.Expr)
+		case ast.IsExpr:
+			walk(v.Expr)
+		case ast.UniqueExpr:
+			walk(v.Expr)
+		case ast.CallExpr:
+			for _, arg := range v.Args {
+				walk(arg)
+			}
+		case ast.ListExpr:
+			for _, e := range v.Elements {
+				walk(e)
+			}
+		}
+	}
+	for _, expr := range exprs {
+		walk(expr)
+	}
+	return names
+}
+
+func isField(expr ast.Expr, field string) bool {
+	name, ok := fieldName(expr)
+	return ok && name == field
 }
@@ -3,38 +3,785 @@ package engine
 import (
 	"customs/ast"
 	analyzer2 "customs/ast/analyzer"
+	parser2 "customs/ast/parser"
+	"customs/ast/scanner"
 	"testing"
 )
 
+func generate(t *testing.T, input string) string {
+	t.Helper()
+	out, _ := generateDiagnostics(t, input)
+	return out
+}
+
+// generateDiagnostics returns the schema along with the warnings about the assertions left out of it.
+func generateDiagnostics(t *testing.T, input string) (string, ast.Diagnostics) {
+	t.Helper()
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	parser := parser2.NewParser(lexer.Tokens)
+	stmts, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	analyzer := analyzer2.NewAnalyzer(stmts)
+	if err := analyzer.Analyze(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+
+	resolver := NewResolver(analyzer.Stmt)
+	resolver.Compute()
+
+	g := NewGenerator(resolver, resolver.Stmts)
+	out, err := g.GenerateYaml()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
+	return string(out), g.Diagnostics
+}
+
 func TestGenerator_TestGenerateYaml(t *testing.T) {
 	input := `
 	let x = 10 - 2 + 3;
 	constraint RegisterApi {
 		let y = 10;
-		assert Token (t) => {
-			t > y * x;
+		assert token as t => t > y * x;
+		assert usage as u => u >= y + x;
+		assert extra_info => 100 > extra_info;
+	};
+	`
+
+	out := generate(t, input)
+
+	expected := `RegisterApi:
+  Token:
+  - Gt: 110
+  Usage:
+  - Gte: 21
+  ExtraInfo:
+  - Lt: 100
+`
+	if out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlInheritance(t *testing.T) {
+	input := `
+	abstract constraint Root {
+		let threshold = 2 * (30 - 10 / 2);
+		assert token as t => t > threshold;
+	}
+	constraint RegisterApi extends Root;
+	constraint LoginApi extends Root {
+		let threshold = 10;
+		assert usage => usage < threshold;
+	}
+	`
+
+	expected := `RegisterApi:
+  Token:
+  - Gt: 50
+LoginApi:
+  Token:
+  - Gt: 10
+  Usage:
+  - Lt: 10
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlNested(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		let limit = 64;
+		assert extra_info as info => {
+			assert name => name < limit;
+			assert address {
+				assert zip => zip >= 10000;
+				assert zip => zip <= 99999;
+			};
 		};
-		assert Usage (u) => {
-			u >= y + x;
+		assert extra_info => {
+			assert age => age > 17;
 		};
+		assert token => token > 0;
 	}
 	`
 
-	scanner := ast.Lexer{Text: input}
-	tokens, _ := scanner.Scan()
+	expected := `RegisterApi:
+  ExtraInfo:
+    Name:
+    - Lt: 64
+    Address:
+      Zip:
+      - Gte: 10000
+      - Lte: 99999
+    Age:
+    - Gt: 17
+  Token:
+  - Gt: 0
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
 
-	parser := ast.NewParser(tokens)
-	stmts := parser.Parse()
+func TestGenerator_TestGenerateYamlNestedRules(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert user => {
+			user is not null;
+			assert rules => rules > 1;
+		}
+	}
+	`
 
-	analyzer := analyzer2.NewAnalyzer(stmts)
-	analyzer.Check()
+	expected := `RegisterApi:
+  User:
+    _Rules:
+    - NotNull: true
+    Rules:
+    - Gt: 1
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
 
-	resolver := NewResolver(analyzer.Stmts)
-	resolver.Compute()
+func TestGenerator_TestGenerateYamlReservedKeys(t *testing.T) {
+	input := `
+	/// Registers a user.
+	@owner("payments")
+	constraint RegisterApi {
+		assert description => description != "";
+		assert "when" (w) => w > 1;
+		assert annotations => annotations > 0;
+		assert a => a > 0;
+		when a > 1 {
+			assert required b;
+		}
+	}
+	`
 
-	g := NewGenerator(resolver, resolver.Stmts)
-	g.GenerateYaml()
+	expected := `RegisterApi:
+  _Description: Registers a user.
+  _Annotations:
+    Owner: payments
+  Description:
+  - Ne: ""
+  When:
+  - Gt: 1
+  Annotations:
+  - Gt: 0
+  A:
+  - Gt: 0
+  _When:
+  - If:
+      A:
+      - Gt: 1
+    Then:
+      B:
+      - Required: true
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlAssertBlock(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		let threshold = 2 * (30 - 10 / 2);
+		assert token (t) => {
+			t > threshold;
+			t < 100;
+		};
+		assert usage (u) => {
+			u >= 1;
+			100 >= u;
+		}
+	}
+	`
+
+	expected := `RegisterApi:
+  Token:
+  - Gt: 50
+  - Lt: 100
+  Usage:
+  - Gte: 1
+  - Lte: 100
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlIsExpr(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert usage => usage is not empty;
+		assert nickname => nickname is empty;
+		assert referrer => referrer is not null;
+		assert id => { id is integer; id is not null; }
+		assert extra_info => { extra_info is not string; }
+	}
+	`
+
+	expected := `RegisterApi:
+  Usage:
+  - NotEmpty: true
+  Nickname:
+  - Empty: true
+  Referrer:
+  - NotNull: true
+  Id:
+  - Type: integer
+  - NotNull: true
+  ExtraInfo:
+  - NotType: string
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlLogical(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert token (t) => t > 1 and t < 100;
+		assert usage (u) => u < 10 or u > 20 and u < 30;
+		assert referrer (r) => r is null or r == "home";
+		assert id => id > 0 or id == id;
+		assert nickname => nickname == null or nickname != "";
+		assert verified => { verified == true; verified != null; }
+	}
+	`
+
+	expected := `RegisterApi:
+  Token:
+  - Gt: 1
+  - Lt: 100
+  Usage:
+  - AnyOf:
+    - - Lt: 10
+    - - Gt: 20
+      - Lt: 30
+  Referrer:
+  - AnyOf:
+    - - "Null": true
+    - - Eq: home
+  Id: []
+  Nickname:
+  - AnyOf:
+    - - "Null": true
+    - - Ne: ""
+  Verified:
+  - Eq: true
+  - NotNull: true
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlFieldNames(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert address2 => address2 is not empty;
+		assert "content-type" (c) => c == "application/json";
+		assert ` + "`not`" + ` (n) => n > 0;
+		assert user.address.zip (z) => z > 999;
+		assert user.name => name is not empty;
+		assert user => { assert age => age >= 18; }
+	}
+	`
+
+	expected := `RegisterApi:
+  Address2:
+  - NotEmpty: true
+  ContentType:
+  - Eq: application/json
+  Not:
+  - Gt: 0
+  User:
+    Address:
+      Zip:
+      - Gt: 999
+    Name:
+    - NotEmpty: true
+    Age:
+    - Gte: 18
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlStringExpr(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		let prefix = "api" + "_";
+		assert token (t) => t startsWith prefix and t endsWith "_key" and t contains "live";
+		assert phone => phone matches ` + "`^\\+[0-9]{8,15}$`" + `;
+		assert name => name >= "a" and "live" contains name;
+	}
+	`
+
+	expected := `RegisterApi:
+  Token:
+  - StartsWith: api_
+  - EndsWith: _key
+  - Contains: live
+  Phone:
+  - Matches: ^\+[0-9]{8,15}$
+  Name:
+  - Gte: a
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlCallExpr(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		let limit = max(32, 64);
+		assert name (n) => len(n) <= limit and len(n) > 0;
+		assert code => { len(code) == 6; 10 > len(code); }
+		assert slug => len(slug) < 20 and lower(slug) == slug;
+		assert expires_at: string format date-time => expires_at > now();
+		assert issued_at => issued_at <= now() and now() - 3600 < issued_at;
+	}
+	`
+
+	expected := `RegisterApi:
+  Name:
+  - MaxLength: 64
+  - MinLength: 1
+  Code:
+  - Length: 6
+  - MaxLength: 9
+  Slug:
+  - MaxLength: 19
+  ExpiresAt:
+  - Type: string
+  - Format: date-time
+  - GtNow: 0
+  IssuedAt:
+  - LteNow: 0
+  - GtNow: -3600
+`
+	out, diagnostics := generateDiagnostics(t, input)
+	if out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+	// lower(slug) == slug is not a rule of the schema
+	if len(diagnostics) != 1 || diagnostics[0].Error() != "[6:37] W002 Assertion cannot be rendered as rules, it is left out of the schema" {
+		t.Errorf("Diagnostics = %v, want the comparison of lower(slug)", diagnostics)
+	}
+}
+
+func TestGenerator_TestGenerateYamlUnrendered(t *testing.T) {
+	input := `
+	abstract constraint BaseApi {
+		assert expires_at => expires_at == now() and expires_at > now() * 2;
+	}
+	constraint RegisterApi extends BaseApi {
+		assert total => total > price * 2;
+		assert price => price > 0;
+	}
+	constraint RefundApi extends BaseApi {
+		assert id => id > 0;
+	}
+	`
+
+	// the inherited asserts are reported once
+	expected := []string{
+		"[3:24] W002 Assertion cannot be rendered as rules, it is left out of the schema",
+		"[3:48] W002 Assertion cannot be rendered as rules, it is left out of the schema",
+		"[6:19] W002 Assertion cannot be rendered as rules, it is left out of the schema",
+	}
+	_, diagnostics := generateDiagnostics(t, input)
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Diagnostics = %v, want %v", diagnostics, expected)
+	}
+	for i, d := range diagnostics {
+		if d.Error() != expected[i] {
+			t.Errorf("Diagnostics[%d] = %q, want %q", i, d, expected[i])
+		}
+	}
+}
+
+func TestGenerator_TestGenerateYamlEnum(t *testing.T) {
+	input := `
+	enum Currency { "EUR", "USD", }
+	constraint RegisterApi {
+		let reserved = ["admin", "root"];
+		assert currency => currency in Currency;
+		assert name => name not in reserved and len(name) > 2;
+		assert level => level in [1, 2, 3] or level == 10;
+	}
+	`
+
+	expected := `RegisterApi:
+  Currency:
+  - Enum:
+    - EUR
+    - USD
+  Name:
+  - NotEnum:
+    - admin
+    - root
+  - MinLength: 3
+  Level:
+  - AnyOf:
+    - - Enum:
+        - 1
+        - 2
+        - 3
+    - - Eq: 10
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlCrossField(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert username => len(username) >= 3;
+		assert password (p) => p != username and len(p) >= 8;
+		assert (start, end) => start > 0 and start < end;
+		assert deadline => end <= deadline;
+		assert retries => 5 >= retries and username startsWith retries;
+		assert window => { assert to => { to > from; } assert from => from > 0; }
+	}
+	`
+
+	expected := `RegisterApi:
+  Username:
+  - MinLength: 3
+  Password:
+  - NeField: Username
+  - MinLength: 8
+  Start:
+  - Gt: 0
+  - LtField: End
+  Deadline:
+  - GteField: End
+  Retries:
+  - Lte: 5
+  Window:
+    To:
+    - GtField: From
+    From:
+    - Gt: 0
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlWhen(t *testing.T) {
+	input := `
+	abstract constraint Payment {
+		assert method => method in ["card", "bank"];
+		when method == "card" {
+			assert card_number => card_number is not empty;
+		}
+	}
+	constraint RegisterApi extends Payment {
+		let limit = 100;
+		assert amount => amount > 0;
+		when method == "bank" and amount >= limit {
+			assert iban => len(iban) <= 34;
+			assert billing.country => country != null;
+		}
+		when method == "bank" or amount < len(method) {
+			assert reference => reference is not empty;
+		}
+		when channel == "web" and amount < limit {
+			assert required session;
+		}
+	}
+	`
+
+	expected := `RegisterApi:
+  Method:
+  - Enum:
+    - card
+    - bank
+  Amount:
+  - Gt: 0
+  _When:
+  - If:
+      Method:
+      - Eq: card
+    Then:
+      CardNumber:
+      - NotEmpty: true
+  - If:
+      Method:
+      - Eq: bank
+      Amount:
+      - Gte: 100
+    Then:
+      Iban:
+      - MaxLength: 34
+      Billing:
+        Country:
+        - NotNull: true
+  - If:
+      Channel:
+      - Eq: web
+      Amount:
+      - Lt: 100
+    Then:
+      Session:
+      - Required: true
+`
+	out, diagnostics := generateDiagnostics(t, input)
+	if out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+	// the guard with 'or' is not rendered, its asserts are reported along with it
+	if len(diagnostics) != 1 || diagnostics[0].Error() != "[15:8] W002 Guard cannot be rendered as rules, its asserts are left out of the schema" {
+		t.Errorf("Diagnostics = %v", diagnostics)
+	}
+}
+
+func TestGenerator_TestGenerateYamlQuantifiers(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert items => count(items) <= 50 and count(items) > 0 and items unique by sku;
+		assert items each (i) => { i.qty > 0; len(i.sku) <= 12 and i.meta.tag != null; i.price > i.qty; };
+		assert addresses any (a) => a.primary == true;
+		assert tags all (t) => len(t) < 20;
+		assert ids => ids unique;
+		assert codes each (c) => c == now();
+	}
+	`
+
+	expected := `RegisterApi:
+  Items:
+  - MaxCount: 50
+  - MinCount: 1
+  - UniqueBy: Sku
+  - Each:
+      Qty:
+      - Gt: 0
+      Sku:
+      - MaxLength: 12
+      Meta:
+        Tag:
+        - NotNull: true
+      Price:
+      - GtField: Qty
+  Addresses:
+  - Any:
+      Primary:
+      - Eq: true
+  Tags:
+  - Each:
+    - MaxLength: 19
+  Ids:
+  - Unique: true
+  Codes: []
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlDeclarations(t *testing.T) {
+	input := `
+	constraint RegisterApi {
+		assert email: string format email (e) => len(e) <= 254;
+		assert id: uuid;
+		assert created_at: date-time;
+		assert age: integer => age >= 18;
+		assert tags: list each (t) => len(t) < 20;
+	}
+	`
+
+	expected := `RegisterApi:
+  Email:
+  - Type: string
+  - Format: email
+  - MaxLength: 254
+  Id:
+  - Type: string
+  - Format: uuid
+  CreatedAt:
+  - Type: string
+  - Format: date-time
+  Age:
+  - Type: integer
+  - Gte: 18
+  Tags:
+  - Type: list
+  - Each:
+    - MaxLength: 19
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlModifiers(t *testing.T) {
+	input := `
+	let limit = 10;
+	constraint ListApi {
+		assert required id: uuid;
+		assert optional page: integer default 1 => page >= 1;
+		assert size: integer default limit * 2;
+		assert nullable cursor: string default null;
+		assert optional filter {
+			assert required field;
+		}
+		assert required id => id != "";
+	}
+	`
 
-	//fmt.Printf("%v\n", g.Stmts)
-	//fmt.Printf("%v\n", g.Token)
+	expected := `ListApi:
+  Id:
+  - Required: true
+  - Type: string
+  - Format: uuid
+  - Ne: ""
+  Page:
+  - Required: false
+  - Type: integer
+  - Default: 1
+  - Gte: 1
+  Size:
+  - Type: integer
+  - Default: 20
+  Cursor:
+  - Nullable: true
+  - Type: string
+  - Default: null
+  Filter:
+    _Rules:
+    - Required: false
+    Field:
+    - Required: true
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlViolations(t *testing.T) {
+	input := `
+	let threshold = 40;
+	let scopes = ["read", "write"];
+	constraint TokenApi {
+		assert t => t > threshold else "token must exceed {threshold}" code "TOKEN_TOO_SMALL";
+		assert scope => {
+			scope in scopes else "scope must be one of {scopes}";
+			len(scope) < 10 code "SCOPE_TOO_LONG";
+		}
+		assert items each (i) => i > 0 else "items must be positive";
+	}
+	`
+
+	expected := `TokenApi:
+  T:
+  - Gt: 40
+    Message: token must exceed 40
+    Code: TOKEN_TOO_SMALL
+  Scope:
+  - Enum:
+    - read
+    - write
+    Message: scope must be one of read, write
+  - MaxLength: 9
+    Code: SCOPE_TOO_LONG
+  Items:
+  - Each:
+    - Gt: 0
+      Message: items must be positive
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlAnnotations(t *testing.T) {
+	input := `
+	let release = "2024-03";
+	@deprecated("use v2")
+	@since(release)
+	@owner("payments")
+	constraint PayApi {
+		@severity(warning)
+		@example(42)
+		assert amount => amount > 0;
+		@deprecated("use currency_code")
+		assert optional currency;
+	}
+	`
+
+	expected := `PayApi:
+  _Annotations:
+    Deprecated: use v2
+    Since: 2024-03
+    Owner: payments
+  Amount:
+  - Annotations:
+      Severity: warning
+      Example: 42
+  - Gt: 0
+  Currency:
+  - Annotations:
+      Deprecated: use currency_code
+  - Required: false
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
+}
+
+func TestGenerator_TestGenerateYamlDescriptions(t *testing.T) {
+	input := `
+	/// Creates a payment.
+	/// The amount is charged at once.
+	@owner("payments")
+	constraint PayApi {
+		/// Amount in cents.
+		assert amount => amount > 0;
+		// an ordinary comment
+		//// a separator
+		assert user {
+			///   Shown on the receipt.
+			assert name => name != "";
+		}
+	}
+	`
+
+	expected := `PayApi:
+  _Description: |-
+    Creates a payment.
+    The amount is charged at once.
+  _Annotations:
+    Owner: payments
+  Amount:
+  - Description: Amount in cents.
+  - Gt: 0
+  User:
+    Name:
+    - Description: Shown on the receipt.
+    - Ne: ""
+`
+	if out := generate(t, input); out != expected {
+		t.Errorf("GenerateYaml() = %s, want %s", out, expected)
+	}
 }
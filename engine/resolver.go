@@ -2,18 +2,29 @@ package engine
 
 import (
 	"customs/ast"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
+type Value struct {
+	Val interface{}
+	Typ ast.LiteralType
+}
+
 type Resolver struct {
-	Stmts []ast.Stmt
-	Token map[string]ast.Token
+	Stmts  []ast.Stmt
+	scopes []map[string]Value
 }
 
 func NewResolver(stmts []ast.Stmt) *Resolver {
-	return &Resolver{Stmts: stmts, Token: make(map[string]ast.Token)}
+	return &Resolver{Stmts: stmts, scopes: []map[string]Value{make(map[string]Value)}}
 }
 
+// Compute folds the top level let statements, constraint scoped lets are folded by Enter.
 func (r *Resolver) Compute() {
 	for i := range r.Stmts {
 		r.ComputeStmt(&r.Stmts[i])
@@ -21,142 +32,351 @@ func (r *Resolver) Compute() {
 }
 
 func (r *Resolver) ComputeStmt(stmt *ast.Stmt) {
-	if letStmt, ok := (*stmt).(ast.LetStmt); ok {
-		r.ComputeLetStmt(&letStmt)
-		*stmt = letStmt
+	switch stmt := (*stmt).(type) {
+	case ast.AssignStmt:
+		r.ComputeAssignStmt(stmt)
+	case ast.EnumStmt:
+		v, typ := r.ComputeListExpr(ast.ListExpr{Elements: stmt.Values})
+		r.scopes[len(r.scopes)-1][stmt.Id.Literal] = Value{Val: v, Typ: typ}
+	}
+}
+
+// Enter opens the scope of a constraint and folds its let statements.
+func (r *Resolver) Enter(stmt ast.ConstraintStmt) {
+	r.scopes = append(r.scopes, make(map[string]Value))
+	for _, letStmt := range stmt.LetStmts {
+		r.ComputeAssignStmt(letStmt)
 	}
+}
 
-	if constraintStmt, ok := (*stmt).(ast.ConstraintStmt); ok {
-		for i := range constraintStmt.Block {
-			r.ComputeStmt(&constraintStmt.Block[i])
+// Leave closes the scope opened by Enter.
+func (r *Resolver) Leave() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *Resolver) Lookup(name string) (Value, bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if v, ok := r.scopes[i][name]; ok {
+			return v, true
 		}
 	}
+	return Value{}, false
 }
 
-func (r *Resolver) ComputeLetStmt(stmt *ast.LetStmt) {
-	v, typ := r.ComputeExpr(stmt.Exp)
-	stmt.Ident.Val = v
-	stmt.Ident.Typ = typ
-	r.Token[stmt.Ident.Literal] = stmt.Ident
+func (r *Resolver) ComputeAssignStmt(stmt ast.AssignStmt) {
+	v, typ := r.ComputeExpr(stmt.Expr)
+	r.scopes[len(r.scopes)-1][stmt.Id.Literal] = Value{Val: v, Typ: typ}
 }
 
-func (r *Resolver) ComputeExpr(expr ast.Expr) (interface{}, ast.Type) {
+func (r *Resolver) ComputeExpr(expr ast.Expr) (interface{}, ast.LiteralType) {
 	switch expr := expr.(type) {
 	case ast.BinaryExpr:
 		return r.ComputeBinaryExpr(expr)
 	case ast.UnaryExpr:
 		return r.ComputeUnaryExpr(expr)
+	case ast.IsExpr:
+		return r.ComputeIsExpr(expr)
+	case ast.CallExpr:
+		return r.ComputeCallExpr(expr)
+	case ast.ListExpr:
+		return r.ComputeListExpr(expr)
 	case ast.Token:
-		tmp, typ := r.ComputeToken(expr)
-		expr.Val = tmp
-		return tmp, typ
+		return r.ComputeToken(expr)
 	}
 	return nil, ast.Any
 }
 
-func (r *Resolver) ComputeBinaryExpr(expr ast.BinaryExpr) (interface{}, ast.Type) {
-	switch typ := expr.Operator.TokenType; typ {
-	case ast.Plus:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
+func (r *Resolver) ComputeBinaryExpr(expr ast.BinaryExpr) (interface{}, ast.LiteralType) {
+	left, t := r.ComputeExpr(expr.Left)
+	right, k := r.ComputeExpr(expr.Right)
+	if t == ast.Any || k == ast.Any {
+		return nil, ast.Any
+	}
+	if t == ast.String && k == ast.String {
+		return computeString(expr.Op.TokenType, left.(string), right.(string))
+	}
+	switch expr.Op.TokenType {
+	case ast.Plus, ast.Minus, ast.Multiply, ast.Divide, ast.Modulo:
 		if t == ast.Integer && k == ast.Integer {
-			return left.(int) + right.(int), ast.Integer
+			return computeInteger(expr.Op.TokenType, left.(int), right.(int))
 		}
-		if t == ast.Float && k == ast.Float {
-			return left.(float64) + right.(float64), ast.Float
+		if (t == ast.Integer || t == ast.Float) && (k == ast.Integer || k == ast.Float) {
+			return computeFloat(expr.Op.TokenType, toFloat(left), toFloat(right))
 		}
-	case ast.Minus:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == ast.Integer && k == ast.Integer {
-			return left.(int) - right.(int), ast.Integer
+	case ast.Equal:
+		if t == k || t == ast.Nil || k == ast.Nil {
+			return t == k && same(left, right), ast.Boolean
 		}
-		if t == ast.Float && k == ast.Float {
-			return left.(float64) - right.(float64), ast.Float
+		if isNumber(t) && isNumber(k) {
+			return toFloat(left) == toFloat(right), ast.Boolean
 		}
-	case ast.Mul:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == ast.Integer && k == ast.Integer {
-			return left.(int) * right.(int), ast.Integer
+	case ast.NotEqual:
+		if t == k || t == ast.Nil || k == ast.Nil {
+			return t != k || !same(left, right), ast.Boolean
 		}
-		if t == ast.Float && k == ast.Float {
-			return left.(float64) * right.(float64), ast.Float
+		if isNumber(t) && isNumber(k) {
+			return toFloat(left) != toFloat(right), ast.Boolean
 		}
-	case ast.Div:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == ast.Integer && k == ast.Integer {
-			return left.(int) / right.(int), ast.Integer
+	case ast.LessThan, ast.LessThanOrEqual, ast.GreaterThan, ast.GreaterThanOrEqual:
+		if isNumber(t) && isNumber(k) {
+			return compare(expr.Op.TokenType, toFloat(left), toFloat(right)), ast.Boolean
 		}
-		if t == ast.Float && k == ast.Float {
-			return left.(float64) / right.(float64), ast.Float
+	case ast.In, ast.NotIn:
+		if k == ast.List {
+			found := false
+			for _, element := range right.([]interface{}) {
+				found = found || same(left, element)
+			}
+			return found == (expr.Op.TokenType == ast.In), ast.Boolean
 		}
-	case ast.Equal:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == k {
-			return left == right, ast.Bool
+	case ast.And:
+		if t == ast.Boolean && k == ast.Boolean {
+			return left.(bool) && right.(bool), ast.Boolean
 		}
-	case ast.NotEqual:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == k {
-			return left != right, ast.Bool
-		}
-	case ast.Lt:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == k {
-			return left.(int) < right.(int), ast.Bool
-		}
-	case ast.Gt:
-		left, t := r.ComputeExpr(expr.Left)
-		right, k := r.ComputeExpr(expr.Right)
-		if t == k {
-			return left.(int) > right.(int), ast.Bool
+	case ast.Or:
+		if t == ast.Boolean && k == ast.Boolean {
+			return left.(bool) || right.(bool), ast.Boolean
 		}
-	default:
-		panic("Unresolved binary operator")
 	}
-	r.ComputeExpr(expr.Left)
-	r.ComputeExpr(expr.Right)
 	return nil, ast.Any
 }
 
-func (r *Resolver) ComputeUnaryExpr(expr ast.UnaryExpr) (interface{}, ast.Type) {
-	switch typ := expr.Operator.TokenType; typ {
+func (r *Resolver) ComputeUnaryExpr(expr ast.UnaryExpr) (interface{}, ast.LiteralType) {
+	v, typ := r.ComputeExpr(expr.Expr)
+	switch expr.Op.TokenType {
 	case ast.Plus:
-		return r.ComputeExpr(expr.Right)
+		return v, typ
 	case ast.Minus:
-		v, exprType := r.ComputeExpr(expr.Right)
-		switch exprType {
+		switch typ {
 		case ast.Integer:
 			return -v.(int), ast.Integer
 		case ast.Float:
 			return -v.(float64), ast.Float
 		}
-	default:
-		panic("Unresolved unary operator")
+	case ast.Not:
+		if typ == ast.Boolean {
+			return !v.(bool), ast.Boolean
+		}
 	}
 	return nil, ast.Any
 }
 
-func (r *Resolver) ComputeToken(token ast.Token) (interface{}, ast.Type) {
+func (r *Resolver) ComputeIsExpr(expr ast.IsExpr) (interface{}, ast.LiteralType) {
+	v, typ := r.ComputeExpr(expr.Expr)
+	if typ == ast.Any {
+		return nil, ast.Any
+	}
+	var holds bool
+	switch expr.Predicate.TokenType {
+	case ast.Empty:
+		holds = v == ""
+	case ast.Null:
+		holds = typ == ast.Nil
+	default:
+		holds = ast.TypeNames[expr.Predicate.Literal] == typ
+	}
+	return holds != expr.Not, ast.Boolean
+}
+
+// ComputeCallExpr folds a call whose arguments are all known, functions of the request such as now are not folded.
+func (r *Resolver) ComputeCallExpr(expr ast.CallExpr) (interface{}, ast.LiteralType) {
+	function, ok := Functions[expr.Callee.Literal]
+	if !ok {
+		return nil, ast.Any
+	}
+	args := make([]Value, 0, len(expr.Args))
+	for _, arg := range expr.Args {
+		v, typ := r.ComputeExpr(arg)
+		if typ == ast.Any {
+			return nil, ast.Any
+		}
+		args = append(args, Value{Val: v, Typ: typ})
+	}
+	return function(args)
+}
+
+// ComputeListExpr folds a list whose elements are all known.
+func (r *Resolver) ComputeListExpr(expr ast.ListExpr) (interface{}, ast.LiteralType) {
+	elements := make([]interface{}, 0, len(expr.Elements))
+	for _, element := range expr.Elements {
+		v, typ := r.ComputeExpr(element)
+		if typ == ast.Any {
+			return nil, ast.Any
+		}
+		elements = append(elements, v)
+	}
+	return elements, ast.List
+}
+
+func (r *Resolver) ComputeToken(token ast.Token) (interface{}, ast.LiteralType) {
 	if token.TokenType == ast.Ident {
-		if v, ok := r.Token[token.Literal]; ok {
+		if v, ok := r.Lookup(token.Literal); ok {
 			return v.Val, v.Typ
-		} else {
-			return nil, ast.Any
 		}
+		return nil, ast.Any
 	}
-	switch token.Typ {
+	if token.TokenType == ast.Null {
+		return nil, ast.Nil
+	}
+	if token.TokenType != ast.Value {
+		return nil, ast.Any
+	}
+	switch token.LiteralType {
 	case ast.Integer:
-		v, _ := strconv.Atoi(token.Literal)
+		v, err := strconv.Atoi(token.Literal)
+		if err != nil {
+			return nil, ast.Any
+		}
 		return v, ast.Integer
-	case ast.Bool:
-		return token.Literal == "true", ast.Bool
-	default:
-		panic("Unresolved token type")
+	case ast.Float:
+		v, err := strconv.ParseFloat(token.Literal, 64)
+		if err != nil {
+			return nil, ast.Any
+		}
+		return v, ast.Float
+	case ast.String:
+		return token.Literal, ast.String
+	case ast.Boolean:
+		return token.Literal == "true", ast.Boolean
+	}
+	return nil, ast.Any
+}
+
+// Interpolate replaces the `{name}` placeholders of a violation message by the value of the lets they name,
+// a placeholder which cannot be resolved is kept as written.
+func (r *Resolver) Interpolate(message string) string {
+	return ast.Placeholder.ReplaceAllStringFunc(message, func(placeholder string) string {
+		v, ok := r.Lookup(placeholder[1 : len(placeholder)-1])
+		if !ok || v.Typ == ast.Any {
+			return placeholder
+		}
+		return display(v.Val)
+	})
+}
+
+// display renders a folded value as it is written in a message, lists as their comma separated elements.
+func display(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []interface{}:
+		elements := make([]string, 0, len(v))
+		for _, element := range v {
+			elements = append(elements, display(element))
+		}
+		return strings.Join(elements, ", ")
+	}
+	return fmt.Sprint(v)
+}
+
+func computeInteger(op ast.TokenType, left, right int) (interface{}, ast.LiteralType) {
+	switch op {
+	case ast.Plus:
+		return left + right, ast.Integer
+	case ast.Minus:
+		return left - right, ast.Integer
+	case ast.Multiply:
+		return left * right, ast.Integer
+	case ast.Divide:
+		if right == 0 {
+			return nil, ast.Any
+		}
+		return left / right, ast.Integer
+	case ast.Modulo:
+		if right == 0 {
+			return nil, ast.Any
+		}
+		return left % right, ast.Integer
+	}
+	return nil, ast.Any
+}
+
+func computeFloat(op ast.TokenType, left, right float64) (interface{}, ast.LiteralType) {
+	switch op {
+	case ast.Plus:
+		return left + right, ast.Float
+	case ast.Minus:
+		return left - right, ast.Float
+	case ast.Multiply:
+		return left * right, ast.Float
+	case ast.Divide:
+		if right == 0 {
+			return nil, ast.Any
+		}
+		return left / right, ast.Float
+	case ast.Modulo:
+		if right == 0 {
+			return nil, ast.Any
+		}
+		return math.Mod(left, right), ast.Float
+	}
+	return nil, ast.Any
+}
+
+func computeString(op ast.TokenType, left, right string) (interface{}, ast.LiteralType) {
+	switch op {
+	case ast.Plus:
+		return left + right, ast.String
+	case ast.Equal:
+		return left == right, ast.Boolean
+	case ast.NotEqual:
+		return left != right, ast.Boolean
+	case ast.LessThan, ast.LessThanOrEqual, ast.GreaterThan, ast.GreaterThanOrEqual:
+		return compare(op, float64(strings.Compare(left, right)), 0), ast.Boolean
+	case ast.Contains:
+		return strings.Contains(left, right), ast.Boolean
+	case ast.StartsWith:
+		return strings.HasPrefix(left, right), ast.Boolean
+	case ast.EndsWith:
+		return strings.HasSuffix(left, right), ast.Boolean
+	case ast.Matches:
+		pattern, err := regexp.Compile(right)
+		if err != nil {
+			return nil, ast.Any
+		}
+		return pattern.MatchString(left), ast.Boolean
+	}
+	return nil, ast.Any
+}
+
+func compare(op ast.TokenType, left, right float64) bool {
+	switch op {
+	case ast.LessThan:
+		return left < right
+	case ast.LessThanOrEqual:
+		return left <= right
+	case ast.GreaterThan:
+		return left > right
+	case ast.GreaterThanOrEqual:
+		return left >= right
+	}
+	return false
+}
+
+// same compares two folded values, integers and floats are compared by value and lists element by element.
+func same(a, b interface{}) bool {
+	_, i := a.(int)
+	_, f := a.(float64)
+	_, j := b.(int)
+	_, g := b.(float64)
+	if (i || f) && (j || g) {
+		return toFloat(a) == toFloat(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isNumber(typ ast.LiteralType) bool {
+	return typ == ast.Integer || typ == ast.Float
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
 	}
+	return 0
 }
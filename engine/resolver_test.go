@@ -3,32 +3,76 @@ package engine
 import (
 	"customs/ast"
 	analyzer2 "customs/ast/analyzer"
-	"fmt"
+	parser2 "customs/ast/parser"
+	"customs/ast/scanner"
 	"testing"
 )
 
 func TestResolver_TestCompute(t *testing.T) {
 	input := `
 	let x = 10 - 2 + 3;
-	constraint RegisterApi {
-		let y = 10;
-		assert token (t) => {
-			t > y;
-		}
-	}
+	let y = x * 2.5;
+	let z = -x;
+	let m = x%4;
+	let f = y % 2;
+	let b = x!=11;
+	let h = 0xFF + 1_000;
+	let e = 1e-3 * 2;
+	let t = true and not false;
+	let n = null == null;
+	let o = x != null;
+	let s = "api" + "_" + "v1";
+	let c = s contains "_v" and s startsWith "api" and not (s endsWith "v2");
+	let l = len("héllo") + abs(-2) + min(3, 1.5, 2) * max(-1, -2);
+	let u = upper(s) + lower("_X");
+	enum Level { 1, 2, 3 }
+	let k = count(["a", "b"]) + count([]);
+	let i = 2.0 in Level and "b" not in ["a", "c"] and [1, 2] == [1, 2];
+	let r = s matches ` + "`^api_v[0-9]$`" + ` and "abc" < "abd";
 	`
 
-	scanner := ast.Lexer{Text: input}
-	tokens, _ := scanner.Scan()
+	lexer := scanner.NewLexer(input)
+	if err := lexer.Scan(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
 
-	parser := ast.NewParser(tokens)
-	stmts := parser.Parse()
+	parser := parser2.NewParser(lexer.Tokens)
+	stmts, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
 
 	analyzer := analyzer2.NewAnalyzer(stmts)
-	analyzer.Check()
+	if err := analyzer.Analyze(); err != nil {
+		t.Fatalf("Error = %v\n", err)
+	}
 
-	g := NewResolver(analyzer.Stmts)
+	g := NewResolver(analyzer.Stmt)
 	g.Compute()
-	fmt.Printf("%v\n", g.Stmts)
-	fmt.Printf("%v\n", g.Token)
+
+	expected := map[string]Value{
+		"x": {Val: 11, Typ: ast.Integer},
+		"y": {Val: 27.5, Typ: ast.Float},
+		"z": {Val: -11, Typ: ast.Integer},
+		"m": {Val: 3, Typ: ast.Integer},
+		"f": {Val: 1.5, Typ: ast.Float},
+		"b": {Val: false, Typ: ast.Boolean},
+		"h": {Val: 1255, Typ: ast.Integer},
+		"e": {Val: 0.002, Typ: ast.Float},
+		"t": {Val: true, Typ: ast.Boolean},
+		"n": {Val: true, Typ: ast.Boolean},
+		"o": {Val: true, Typ: ast.Boolean},
+		"s": {Val: "api_v1", Typ: ast.String},
+		"c": {Val: true, Typ: ast.Boolean},
+		"r": {Val: true, Typ: ast.Boolean},
+		"i": {Val: true, Typ: ast.Boolean},
+		"k": {Val: 2, Typ: ast.Integer},
+		"l": {Val: 5.5, Typ: ast.Float},
+		"u": {Val: "API_V1_x", Typ: ast.String},
+	}
+	for name, want := range expected {
+		if got, ok := g.Lookup(name); !ok || got != want {
+			t.Errorf("Lookup(%s) = %v, want %v", name, got, want)
+		}
+	}
 }
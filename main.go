@@ -2,19 +2,248 @@ package main
 
 import (
 	"customs/ast"
+	"customs/ast/analyzer"
+	"customs/ast/parser"
+	"customs/ast/printer"
+	"customs/ast/scanner"
+	"customs/engine"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
+// Exit codes, so that build scripts can tell a broken source apart from a broken invocation.
+const (
+	ExitOk = iota
+	ExitFailure
+	ExitUsage
+)
+
+const usage = `customs compiles API constraints into a YAML schema.
+
+Usage:
+    customs <command> [arguments]
+
+Commands:
+    build    compile a .cus file, e.g. customs build api.cus -o out.yaml
+    check    lex, parse and analyze .cus files without generating output
+    fmt      print .cus files in canonical format, -w rewrites them in place
+    explain  describe a diagnostic code, e.g. customs explain E001
+`
+
 func main() {
-	input := `10 + 2;
-	`
-	lexer := ast.Lexer{Text: input}
-	tokens, err := lexer.Scan()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "build":
+		return build(args[1:], stdout, stderr)
+	case "check":
+		return check(args[1:], stdout, stderr)
+	case "fmt":
+		return format(args[1:], stdout, stderr)
+	case "explain":
+		return explain(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return ExitOk
+	}
+	fmt.Fprintf(stderr, "customs: unknown command %q\n\n%s", args[0], usage)
+	return ExitUsage
+}
+
+func build(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "output file, defaults to the input file with a .yaml extension")
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintln(stderr, "usage: customs build <file.cus> [-o out.yaml]")
+		return ExitUsage
+	}
+
+	stmts, diagnostics, err := compile(files[0])
+	report(stderr, files[0], diagnostics)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	resolver := engine.NewResolver(stmts)
+	resolver.Compute()
+	generator := engine.NewGenerator(resolver, resolver.Stmts)
+	out, err := generator.GenerateYaml()
+	report(stderr, files[0], generator.Diagnostics)
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	if *output == "-" {
+		_, _ = stdout.Write(out)
+		return ExitOk
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(files[0], filepath.Ext(files[0])) + ".yaml"
+	}
+	if err := os.WriteFile(*output, out, 0644); err != nil {
+		return fail(stderr, err)
+	}
+	return ExitOk
+}
+
+func check(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "usage: customs check <file.cus>...")
+		return ExitUsage
+	}
+
+	code := ExitOk
+	for _, file := range files {
+		_, diagnostics, err := compile(file)
+		report(stderr, file, diagnostics)
+		if err != nil {
+			code = fail(stderr, err)
+		}
+	}
+	return code
+}
+
+func format(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	write := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "usage: customs fmt [-w] <file.cus>...")
+		return ExitUsage
+	}
+
+	code := ExitOk
+	for _, file := range files {
+		stmts, comments, diagnostics, err := parse(file)
+		report(stderr, file, diagnostics)
+		if err != nil {
+			code = fail(stderr, err)
+			continue
+		}
+		p := printer.NewPrinter(stmts)
+		p.Comments = comments
+		out := p.Print()
+		if !*write {
+			fmt.Fprint(stdout, out)
+			continue
+		}
+		if err := os.WriteFile(file, []byte(out), 0644); err != nil {
+			code = fail(stderr, err)
+		}
+	}
+	return code
+}
+
+func explain(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: customs explain <code>")
+		return ExitUsage
+	}
+	info, ok := ast.Explain(strings.ToUpper(args[0]))
+	if !ok {
+		fmt.Fprintf(stderr, "customs: unknown code %q, known codes are %s\n", args[0], strings.Join(ast.KnownCodes(), ", "))
+		return ExitUsage
+	}
+	fmt.Fprintf(stdout, "%s (%s): %s\n\n%s\n", info.Code, info.Severity, info.Title, info.Explanation)
+	return ExitOk
+}
+
+// errCompile marks a source file with error diagnostics, which have already been reported.
+var errCompile = errors.New("compilation failed")
+
+// parse runs the lexer and the parser over a source file, comments after the last statement
+// are returned apart from the statements. The lexer skips the characters it cannot scan,
+// so the parser still runs and the syntax errors are reported along with the lexical ones.
+func parse(file string) ([]ast.Stmt, []ast.Comment, ast.Diagnostics, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	lexer := scanner.NewLexer(string(text))
+	scanErr := lexer.Scan()
+	p := parser.NewParser(lexer.Tokens)
+	stmts, err := p.Parse()
+	diagnostics := append(slices.Clip(lexer.Diagnostics), p.Diagnostics...)
+	if scanErr != nil || err != nil {
+		return nil, nil, diagnostics, errCompile
+	}
+	return stmts, p.Comments, diagnostics, nil
+}
+
+// compile parses a source file and runs the semantic analysis over it.
+func compile(file string) ([]ast.Stmt, ast.Diagnostics, error) {
+	stmts, _, diagnostics, err := parse(file)
 	if err != nil {
-		fmt.Println("Error: ", err)
+		return nil, diagnostics, err
+	}
+	a := analyzer.NewAnalyzer(stmts)
+	err = a.Analyze()
+	diagnostics = append(diagnostics, a.Diagnostics...)
+	if err != nil {
+		return nil, diagnostics, errCompile
+	}
+	return a.Stmt, diagnostics, nil
+}
+
+// report prints diagnostics as file:line:column: severity code: message, followed by their notes.
+func report(w io.Writer, file string, diagnostics ast.Diagnostics) {
+	for _, d := range diagnostics {
+		fmt.Fprintf(w, "%s:%d:%d: %s %s: %s\n", file, d.Span.Start.Line, d.Span.Start.Column, d.Severity, d.Code, d.Message)
+		for _, note := range d.Notes {
+			fmt.Fprintf(w, "    note: %s\n", note)
+		}
+		if d.Fix != nil {
+			fmt.Fprintf(w, "    help: %s\n", d.Fix.Message)
+		}
+	}
+}
+
+// fail prints errors which are not diagnostics of the source, such as unreadable files.
+func fail(w io.Writer, err error) int {
+	if err != errCompile {
+		fmt.Fprintf(w, "customs: %v\n", err)
+	}
+	return ExitFailure
+}
+
+// parseArgs parses flags which may appear before or after the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
-	fmt.Printf("tokens => %v\n", tokens)
-	//parser := ast.NewParser(tokens)
-	//stmt, err := parser.ParseExpr()
-	//fmt.Printf("stmt => %v\n", stmt)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSource(t *testing.T, text string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "api.cus")
+	if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestRun_Build(t *testing.T) {
+	file := writeSource(t, `constraint RegisterApi {
+		let threshold = 2 * (30 - 10 / 2);
+		assert token as t => t > threshold;
+	};`)
+	out := filepath.Join(filepath.Dir(file), "out.yaml")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", file, "-o", out}, &stdout, &stderr); code != ExitOk {
+		t.Fatalf("build exited with %d: %s", code, stderr.String())
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "RegisterApi:\n  Token:\n  - Gt: 50\n"
+	if string(data) != expected {
+		t.Errorf("build wrote %s, want %s", data, expected)
+	}
+}
+
+func TestRun_Check(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	file := writeSource(t, `let x = 1; let x = 2;`)
+	if code := run([]string{"check", file}, &stdout, &stderr); code != ExitFailure {
+		t.Errorf("check exited with %d, want %d", code, ExitFailure)
+	}
+	if !strings.Contains(stderr.String(), "already declared") {
+		t.Errorf("check reported %q", stderr.String())
+	}
+
+	// the syntax errors are reported along with the invalid characters
+	stderr.Reset()
+	file = writeSource(t, `let x = 1 # 2; let = 3;`)
+	if code := run([]string{"check", file}, &stdout, &stderr); code != ExitFailure {
+		t.Errorf("check exited with %d, want %d", code, ExitFailure)
+	}
+	if !strings.Contains(stderr.String(), "E002") || !strings.Contains(stderr.String(), "E003") {
+		t.Errorf("check reported %q, want both E002 and E003", stderr.String())
+	}
+
+	file = writeSource(t, `let x = 1;`)
+	if code := run([]string{"check", file}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("check exited with %d, want %d", code, ExitOk)
+	}
+}
+
+func TestRun_Fmt(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	file := writeSource(t, `let   x = 1 +  2;`)
+	if code := run([]string{"fmt", file}, &stdout, &stderr); code != ExitOk {
+		t.Fatalf("fmt exited with %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "let x = 1 + 2;\n" {
+		t.Errorf("fmt printed %q", stdout.String())
+	}
+}
+
+func TestRun_Explain(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"explain", "e001"}, &stdout, &stderr); code != ExitOk {
+		t.Errorf("explain exited with %d", code)
+	}
+	if !strings.HasPrefix(stdout.String(), "E001") {
+		t.Errorf("explain printed %q", stdout.String())
+	}
+	if code := run([]string{"explain", "X999"}, &stdout, &stderr); code != ExitUsage {
+		t.Errorf("explain exited with %d, want %d", code, ExitUsage)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(nil, &stdout, &stderr); code != ExitUsage {
+		t.Errorf("run exited with %d, want %d", code, ExitUsage)
+	}
+	if code := run([]string{"build"}, &stdout, &stderr); code != ExitUsage {
+		t.Errorf("build exited with %d, want %d", code, ExitUsage)
+	}
+}